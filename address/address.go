@@ -0,0 +1,123 @@
+package address
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Address Rejection
+type Error struct {
+	Address string // Address as Received
+	Reason  string // Why it was Rejected
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Invalid Address [%s]: %s", e.Address, e.Reason)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize Parse RFC 5322 Address (Optional Display Name) into Bare Address
+//
+// Display names are validated but dropped, and internationalized domains are
+// converted to punycode. Only the domain is lower cased, as local parts are
+// case sensitive (RFC 5321).
+func Normalize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", &Error{Address: s, Reason: "empty address"}
+	}
+
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", &Error{Address: s, Reason: strings.TrimPrefix(err.Error(), "mail: ")}
+	}
+
+	// Split Local Part and Domain (Local Part can Contain a Quoted '@')
+	i := strings.LastIndex(a.Address, "@")
+	local, domain := a.Address[:i], a.Address[i+1:]
+
+	// Relays are not Required to Support SMTPUTF8
+	if !isASCII(local) {
+		return "", &Error{Address: s, Reason: "internationalized local part not supported"}
+	}
+
+	// Domain Literals (i.e. user@[10.0.0.1]) are not Accepted
+	if strings.HasPrefix(domain, "[") {
+		return "", &Error{Address: s, Reason: "domain literals not supported"}
+	}
+
+	// Convert Internationalized Domain to Punycode (Validates Labels)
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", &Error{Address: s, Reason: "invalid domain: " + err.Error()}
+	}
+
+	// Need a Fully Qualified Domain
+	if !strings.Contains(ascii, ".") {
+		return "", &Error{Address: s, Reason: "domain is not fully qualified"}
+	}
+
+	// Quote Local Part if Required (ParseAddress Unquotes it)
+	bare := (&mail.Address{Address: local + "@" + strings.ToLower(ascii)}).String()
+	return strings.TrimSuffix(strings.TrimPrefix(bare, "<"), ">"), nil
+}
+
+// NormalizeList Normalize List of Addresses (';' or ',' Separated)
+func NormalizeList(s string) (string, error) {
+	var addresses []string
+
+	// Split on ';' First, as ',' can Appear in Quoted Display Names
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		list, err := mail.ParseAddressList(part)
+		if err != nil {
+			return "", &Error{Address: part, Reason: strings.TrimPrefix(err.Error(), "mail: ")}
+		}
+
+		for _, a := range list {
+			n, err := Normalize(a.String())
+			if err != nil {
+				return "", err
+			}
+			addresses = append(addresses, n)
+		}
+	}
+
+	return strings.Join(addresses, ";"), nil
+}
+
+// Domain Domain Part of Bare Address
+func Domain(a string) string {
+	i := strings.LastIndex(a, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return a[i+1:]
+}
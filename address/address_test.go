@@ -0,0 +1,74 @@
+package address
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // Empty if Rejected
+		err  string
+	}{
+		{"bare", "user@example.com", "user@example.com", ""},
+		{"domain lower cased", "User.Name@EXAMPLE.Com", "User.Name@example.com", ""},
+		{"display name", "Ana Silva <Ana@Example.com>", "Ana@example.com", ""},
+		{"quoted display name", `"Silva, Ana" <ana@example.com>`, "ana@example.com", ""},
+		{"quoted local part", `"Ana Silva"@example.com`, `"Ana Silva"@example.com`, ""},
+		{"quoted at", `"ana@home"@example.com`, `"ana@home"@example.com`, ""},
+		{"idn", "ana@Bücher.de", "ana@xn--bcher-kva.de", ""},
+		{"idn display name", "Ana <ana@münchen.de>", "ana@xn--mnchen-3ya.de", ""},
+		{"international local part", "anã@example.com", "", "internationalized local part"},
+		{"domain literal", "ana@[192.0.2.1]", "", "domain literals"},
+		{"not qualified", "ana@localhost", "", "not fully qualified"},
+		{"invalid idn", "ana@exa_mple.com", "", "invalid domain"},
+		{"missing domain", "ana@", "", "Invalid Address"},
+		{"empty", "  ", "", "empty address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.in)
+			if tt.err != "" {
+				if (err == nil) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Normalize [%s] Error [%v] Expected [%s]", tt.in, err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Normalize [%s] Failed [%s]", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize [%s] [%s] Expected [%s]", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeList(t *testing.T) {
+	got, err := NormalizeList(`"Silva, Ana" <Ana@Example.com>, bob@example.com; Carol <carol@EXAMPLE.org>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Ana@example.com;bob@example.com;carol@example.org"; got != want {
+		t.Errorf("NormalizeList [%s] Expected [%s]", got, want)
+	}
+
+	if _, err = NormalizeList("ana@example.com; bob@"); err == nil {
+		t.Error("Invalid Address in List Accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package address
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Recipient Domain MX Validator
+type Validator struct {
+	resolver *net.Resolver // DNS Resolver
+	timeout  time.Duration // Lookup Timeout
+}
+
+// NewValidator Create MX Validator (nil Validator if MX Checks are Disabled)
+func NewValidator(c *config.Validation) *Validator {
+	// Are MX Checks Enabled?
+	if (c == nil) || !c.CheckMX { // NO
+		return nil
+	}
+
+	v := &Validator{
+		resolver: net.DefaultResolver,
+		timeout:  time.Duration(c.Timeout) * time.Second,
+	}
+
+	// Do we Use a Specific DNS Server?
+	if c.Resolver != "" { // YES
+		server := c.Resolver
+		v.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return v
+}
+
+// checkDomain Can Domain Receive Mail? (Temporary DNS Failures are not Errors)
+func (v *Validator) checkDomain(domain string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	mxs, err := v.resolver.LookupMX(ctx, domain)
+	if err == nil {
+		// Null MX (RFC 7505): Domain Accepts no Mail
+		if (len(mxs) == 1) && (strings.TrimSuffix(mxs[0].Host, ".") == "") {
+			return errors.New("domain does not accept mail (null MX)")
+		}
+		return nil
+	}
+
+	// Does the Domain Exist?
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound { // UNKNOWN: Don't Block Mail on DNS Problems
+		log.Printf("[checkDomain] MX Lookup for [%s] Failed [%s]", domain, err)
+		return nil
+	}
+
+	// No MX: Fall Back to Implicit MX (RFC 5321 Section 5.1)
+	_, err = v.resolver.LookupHost(ctx, domain)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		log.Printf("[checkDomain] Host Lookup for [%s] Failed [%s]", domain, err)
+		return nil
+	}
+
+	return errors.New("domain has no mail exchanger")
+}
+
+// Check Validate Domains of Bare Addresses
+func (v *Validator) Check(addresses ...string) error {
+	// Are MX Checks Enabled?
+	if v == nil { // NO
+		return nil
+	}
+
+	checked := map[string]bool{}
+	for _, a := range addresses {
+		d := Domain(a)
+		if (d == "") || checked[d] {
+			continue
+		}
+		checked[d] = true
+
+		err := v.checkDomain(d)
+		if err != nil {
+			return &Error{Address: a, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package address
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Stub Zone Record
+type record struct {
+	mx    string // MX Host ("." Null MX)
+	a     []byte // IPv4 Address
+	rcode dnsmessage.RCode
+}
+
+// dnsStub Serve Zone over UDP until Test Ends (Unknown Names are NXDOMAIN), Returns Address
+func dnsStub(t *testing.T, zone map[string]record) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			name := strings.TrimSuffix(strings.ToLower(q.Name.String()), ".")
+			r, known := zone[name]
+			rcode := r.rcode
+			if !known {
+				rcode = dnsmessage.RCodeNameError
+			}
+
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionAvailable: true, RCode: rcode})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+
+			rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case (q.Type == dnsmessage.TypeMX) && (r.mx != ""):
+				pref := uint16(10)
+				if r.mx == "." {
+					pref = 0
+				}
+				b.MXResource(rh, dnsmessage.MXResource{Pref: pref, MX: dnsmessage.MustNewName(r.mx)})
+			case (q.Type == dnsmessage.TypeA) && (r.a != nil):
+				var a [4]byte
+				copy(a[:], r.a)
+				b.AResource(rh, dnsmessage.AResource{A: a})
+			}
+
+			msg, err := b.Finish()
+			if err == nil {
+				pc.WriteTo(msg, addr)
+			}
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+func TestValidator(t *testing.T) {
+	server := dnsStub(t, map[string]record{
+		"mx.test":       {mx: "mail.mx.test."},
+		"nullmx.test":   {mx: "."},
+		"implicit.test": {a: []byte{192, 0, 2, 1}},
+		"nomail.test":   {},
+		"broken.test":   {rcode: dnsmessage.RCodeServerFailure},
+	})
+
+	v := NewValidator(&config.Validation{CheckMX: true, Resolver: server, Timeout: 2})
+
+	tests := []struct {
+		name    string
+		address string
+		err     string // Empty if Accepted
+	}{
+		{"mx", "user@mx.test", ""},
+		{"null mx", "user@nullmx.test", "null MX"},
+		{"implicit mx", "user@implicit.test", ""},
+		{"no mail exchanger", "user@nomail.test", "no mail exchanger"},
+		{"nonexistent domain", "user@missing.test", "no mail exchanger"},
+		{"dns failure", "user@broken.test", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Check(tt.address)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Check [%s] Failed [%s]", tt.address, err)
+				}
+				return
+			}
+
+			if (err == nil) || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Check [%s] Error [%v] Expected [%s]", tt.address, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidatorDisabled(t *testing.T) {
+	v := NewValidator(&config.Validation{CheckMX: false})
+	if err := v.Check("user@nomail.invalid"); err != nil {
+		t.Errorf("Disabled Validator Failed [%s]", err)
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/poller"
+	"github.com/objectvault/queue-smtp-mailer/status"
+)
+
+// Maximum Request Body Size
+const maxBody = 1 << 20
+
+// Enqueuer Hands Validated Queue Message to the Processing Pipeline
+type Enqueuer func(qm *messages.QueueMessage) error
+
+// Default and Maximum Records Returned by Status Queries
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// HTTP Request Submission and Status API
+type Server struct {
+	config  *config.DaemonConfig // Configuration at Startup (Reloaded Configuration Wins)
+	enqueue Enqueuer             // Where Accepted Messages Go
+	status  *status.Store        // Message Lifecycle Records (nil if not Configured)
+	mux     *http.ServeMux
+}
+
+// New API Server
+func New(c *config.DaemonConfig, enqueue Enqueuer, store *status.Store) *Server {
+	s := &Server{
+		config:  c,
+		enqueue: enqueue,
+		status:  store,
+		mux:     http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/messages", s.authenticated(s.messages))
+	s.mux.HandleFunc("/v1/messages/", s.authenticated(s.getMessage))
+	s.mux.HandleFunc("/debug/vars", s.authenticated(expvar.Handler().ServeHTTP)) // Counters (i.e. Rate Limit Hits)
+	return s
+}
+
+// current Current Configuration
+func (s *Server) current() *config.DaemonConfig {
+	if c := config.Config(); c != nil {
+		return c
+	}
+	return s.config
+}
+
+// settings Current API Settings (Listener Settings if Reload Dropped the Section)
+func (s *Server) settings() *config.HTTP {
+	if h := s.current().HTTP; h != nil {
+		return h
+	}
+	return s.config.HTTP
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run Serve Requests until Context is Cancelled
+func (s *Server) Run(ctx context.Context) error {
+	c := s.config.HTTP
+	server := &http.Server{
+		Addr:              c.Listen,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("START: HTTP API [%s]", c.Listen)
+
+	// Stop Server on Cancel
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+
+		grace := time.Duration(s.current().Options.ShutdownGrace) * time.Second
+		shutdown, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	var err error
+	if c.Cert != "" {
+		err = server.ListenAndServeTLS(c.Cert, c.Key)
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	// Did the Server Stop because of Cancel?
+	if errors.Is(err, http.ErrServerClosed) { // YES
+		<-stopped
+		err = nil
+	}
+
+	log.Print("STOP: HTTP API")
+	return err
+}
+
+// reply Write JSON Response
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// replyError Write JSON Error Response
+func replyError(w http.ResponseWriter, status int, message string) {
+	reply(w, status, map[string]string{"error": message})
+}
+
+// Authorization Header Scheme
+const bearer = "Bearer "
+
+// authenticated Require Valid Bearer Token
+func (s *Server) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Bearer Scheme Required (Scheme is Case Insensitive)
+		token := ""
+		if h := r.Header.Get("Authorization"); (len(h) > len(bearer)) && strings.EqualFold(h[:len(bearer)], bearer) {
+			token = strings.TrimSpace(h[len(bearer):])
+		}
+
+		// Is it a Known Token?
+		valid := false
+		for _, t := range s.settings().Tokens {
+			if (token != "") && (subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1) {
+				valid = true
+			}
+		}
+
+		if !valid { // NO
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mailer"`)
+			replyError(w, http.StatusUnauthorized, "Invalid or Missing Token")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// messages Submit (POST) or Query (GET) Messages
+func (s *Server) messages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.postMessage(w, r)
+	case http.MethodGet:
+		s.findMessages(w, r)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		replyError(w, http.StatusMethodNotAllowed, "Method not Allowed")
+	}
+}
+
+// postMessage Accept Email Request (Same JSON as the Queue Payload)
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		replyError(w, http.StatusRequestEntityTooLarge, "Request too Large")
+		return
+	}
+
+	// Is the Request Valid?
+	qm, err := poller.ToQueueMessage(s.current(), body)
+	if err != nil { // NO
+		replyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = s.enqueue(qm)
+	if err != nil {
+		log.Printf("[api] Failed to Enqueue Message [%s] [%s]", qm.ID(), err)
+		replyError(w, http.StatusServiceUnavailable, "Message could not be Queued")
+		return
+	}
+
+	log.Printf("[api] Accepted Message [%s]", qm.ID())
+	reply(w, http.StatusAccepted, map[string]string{"id": qm.ID()})
+}
+
+// findMessages Query Status Records by Recipient, Template, Stage and Time
+func (s *Server) findMessages(w http.ResponseWriter, r *http.Request) {
+	// Is the Status Store Enabled?
+	if s.status == nil { // NO
+		replyError(w, http.StatusNotFound, "Status Store not Configured")
+		return
+	}
+
+	v := r.URL.Query()
+	q := &status.Query{
+		To:       v.Get("to"),
+		Template: v.Get("template"),
+		Stage:    status.Stage(v.Get("stage")),
+		Limit:    defaultLimit,
+	}
+
+	if since := v.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, "Invalid Value for 'since' (expected RFC3339)")
+			return
+		}
+		q.Since = t
+	}
+
+	if limit := v.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if (err != nil) || (n <= 0) || (n > maxLimit) {
+			replyError(w, http.StatusBadRequest, "Invalid Value for 'limit'")
+			return
+		}
+		q.Limit = n
+	}
+
+	l, err := s.status.Find(q)
+	if err != nil {
+		log.Printf("[api] Status Query Failed [%s]", err)
+		replyError(w, http.StatusInternalServerError, "Status Query Failed")
+		return
+	}
+
+	// Always a List (even if Empty)
+	if l == nil {
+		l = []*status.Record{}
+	}
+	reply(w, http.StatusOK, l)
+}
+
+// getMessage Status Record for Message ID
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		replyError(w, http.StatusMethodNotAllowed, "Method not Allowed")
+		return
+	}
+
+	// Is the Status Store Enabled?
+	if s.status == nil { // NO
+		replyError(w, http.StatusNotFound, "Status Store not Configured")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/messages/")
+	record, err := s.status.Get(id)
+	if err != nil {
+		log.Printf("[api] Status Query Failed [%s]", err)
+		replyError(w, http.StatusInternalServerError, "Status Query Failed")
+		return
+	}
+
+	if record == nil {
+		replyError(w, http.StatusNotFound, "Unknown Message")
+		return
+	}
+	reply(w, http.StatusOK, record)
+}
@@ -0,0 +1,262 @@
+package api
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/events"
+	"github.com/objectvault/queue-smtp-mailer/status"
+)
+
+const validRequest = `{"template": "welcome", "to": "user@example.com", "params": {"name": "User"}}`
+
+func newServer(t *testing.T, enqueue Enqueuer, store ...*status.Store) *httptest.Server {
+	t.Helper()
+
+	c := &config.DaemonConfig{
+		Paths:   &config.Paths{Templates: t.TempDir()},
+		Options: &config.Options{PollQueue: "inbox", ShutdownGrace: 1},
+		HTTP:    &config.HTTP{Listen: ":0", Tokens: []string{"secret"}},
+	}
+
+	var s *status.Store
+	if len(store) > 0 {
+		s = store[0]
+	}
+
+	server := httptest.NewServer(New(c, enqueue, s))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func post(t *testing.T, url string, token string, body string) (*http.Response, map[string]string) {
+	t.Helper()
+
+	r, _ := http.NewRequest(http.MethodPost, url+"/v1/messages", strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reply := map[string]string{}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return resp, reply
+}
+
+func TestPostMessage(t *testing.T) {
+	var queued []*messages.QueueMessage
+	server := newServer(t, func(qm *messages.QueueMessage) error {
+		queued = append(queued, qm)
+		return nil
+	})
+
+	resp, reply := post(t, server.URL, "secret", validRequest)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Unexpected Status [%d] %v", resp.StatusCode, reply)
+	}
+
+	if (len(queued) != 1) || (reply["id"] == "") || (queued[0].ID() != reply["id"]) {
+		t.Fatalf("Message not Queued %v", reply)
+	}
+
+	b, _ := json.Marshal(queued[0])
+	if !strings.Contains(string(b), `"template":"welcome"`) {
+		t.Errorf("Unexpected Message [%s]", b)
+	}
+}
+
+func TestPostMessageRejected(t *testing.T) {
+	failing := errors.New("queue down")
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		err    error
+		status int
+	}{
+		{"no token", "", validRequest, nil, http.StatusUnauthorized},
+		{"wrong token", "guess", validRequest, nil, http.StatusUnauthorized},
+		{"invalid json", "secret", `{`, nil, http.StatusBadRequest},
+		{"missing template", "secret", `{"to": "user@example.com", "params": {}}`, nil, http.StatusBadRequest},
+		{"queue unavailable", "secret", validRequest, failing, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, func(qm *messages.QueueMessage) error {
+				return tt.err
+			})
+
+			resp, reply := post(t, server.URL, tt.token, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Expected [%d] Got [%d] %v", tt.status, resp.StatusCode, reply)
+			}
+
+			if reply["error"] == "" {
+				t.Error("Missing Error Message")
+			}
+		})
+	}
+}
+
+func TestAuthorizationScheme(t *testing.T) {
+	server := newServer(t, func(qm *messages.QueueMessage) error { return nil })
+
+	tests := map[string]int{
+		"secret":         http.StatusUnauthorized, // Missing Scheme
+		"Basic secret":   http.StatusUnauthorized,
+		"Bearersecret":   http.StatusUnauthorized,
+		"Bearer ":        http.StatusUnauthorized,
+		"Bearer secret":  http.StatusAccepted,
+		"bearer  secret": http.StatusAccepted,
+	}
+
+	for header, status := range tests {
+		r, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/messages", strings.NewReader(validRequest))
+		r.Header.Set("Authorization", header)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Errorf("Authorization [%s] Status [%d] Expected [%d]", header, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestReloadWithoutHTTP(t *testing.T) {
+	server := newServer(t, func(qm *messages.QueueMessage) error { return nil })
+
+	// Reloaded Configuration without 'http' Section
+	config.Set(&config.DaemonConfig{
+		Paths:   &config.Paths{Templates: t.TempDir()},
+		Options: &config.Options{PollQueue: "inbox", ShutdownGrace: 1},
+	})
+	defer config.Set(nil)
+
+	if resp, reply := post(t, server.URL, "secret", validRequest); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Unexpected Status [%d] %v", resp.StatusCode, reply)
+	}
+
+	if resp, _ := post(t, server.URL, "guess", validRequest); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unexpected Status [%d]", resp.StatusCode)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	server := newServer(t, func(qm *messages.QueueMessage) error { return nil })
+
+	r, _ := http.NewRequest(http.MethodDelete, server.URL+"/v1/messages", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected Status [%d]", resp.StatusCode)
+	}
+}
+
+func get(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+
+	r, _ := http.NewRequest(http.MethodGet, url, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	json.NewDecoder(resp.Body).Decode(v)
+	return resp.StatusCode
+}
+
+func TestMessageStatus(t *testing.T) {
+	store, err := status.Open(&config.Status{Path: filepath.Join(t.TempDir(), "status.db"), Retention: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	e := events.NewEvent("m1")
+	e.Template, e.To = "welcome", "user@example.com"
+	store.Track(e, status.Received)
+	store.Track(e.Complete(events.StatusSent, nil), status.Stage(e.Status))
+
+	server := newServer(t, nil, store)
+
+	record := &status.Record{}
+	if code := get(t, server.URL+"/v1/messages/m1", record); (code != http.StatusOK) || (record.Stage != "sent") || (len(record.History) != 2) {
+		t.Fatalf("Unexpected Record [%d] %+v", code, record)
+	}
+
+	if code := get(t, server.URL+"/v1/messages/unknown", &map[string]string{}); code != http.StatusNotFound {
+		t.Errorf("Unknown Message [%d]", code)
+	}
+
+	var l []*status.Record
+	if code := get(t, server.URL+"/v1/messages?to=USER@example.com", &l); (code != http.StatusOK) || (len(l) != 1) {
+		t.Errorf("Query by Recipient [%d] %v", code, l)
+	}
+
+	l = nil
+	if code := get(t, server.URL+"/v1/messages?template=other", &l); (code != http.StatusOK) || (len(l) != 0) {
+		t.Errorf("Query by Template [%d] %v", code, l)
+	}
+
+	if code := get(t, server.URL+"/v1/messages?since=yesterday", &l); code != http.StatusBadRequest {
+		t.Errorf("Invalid Query Accepted [%d]", code)
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	server := newServer(t, func(qm *messages.QueueMessage) error { return nil })
+
+	vars := map[string]interface{}{}
+	if code := get(t, server.URL+"/debug/vars", &vars); code != http.StatusOK {
+		t.Fatalf("Unexpected Status [%d]", code)
+	}
+
+	if _, ok := vars["memstats"]; !ok {
+		t.Errorf("Missing Counters %v", vars)
+	}
+
+	// Counters are not Public
+	resp, err := http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected Status [%d]", resp.StatusCode)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/archive"
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// archiveCommand Retrieve Archived Messages (Returns Exit Code)
+func archiveCommand(args []string) int {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Retrieve Archived Messages
+
+		Usage:
+		  server archive [-c /path/to/conf] get <id> [-o file]
+		  server archive [-c /path/to/conf] list <address>
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    -o            Output file [default: stdout].
+
+		  'get' decrypts the message sent for the ID, and outputs it as
+		  sent (MIME). 'list' shows messages archived for an address.
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	sOutput := flags.String("o", "", "Output file")
+	flags.Parse(args)
+
+	// Do we have an Action and Argument?
+	if flags.NArg() < 2 { // NO
+		flags.Usage()
+		return 2
+	}
+
+	// Action Options can Follow the Action
+	action, arg := flags.Arg(0), flags.Arg(1)
+	flags.Parse(flags.Args()[2:])
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Is an Archive Configured?
+	if c.Archive == nil { // NO
+		fmt.Fprintln(os.Stderr, "ERROR: No Archive in Configuration File")
+		return 1
+	}
+
+	a, err := archive.Open(c.Archive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch action {
+	case "get":
+		mime, err := a.Get(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if mime == nil {
+			fmt.Fprintf(os.Stderr, "Not Found [%s]\n", arg)
+			return 1
+		}
+
+		// Write Output
+		var w io.Writer = os.Stdout
+		if *sOutput != "" {
+			f, err := os.OpenFile(*sOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			w = f
+		}
+
+		_, err = w.Write(mime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "list":
+		l, err := a.Find(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		for _, e := range l {
+			fmt.Printf("%s\t%s\n", e.ID, e.Archived.Format(time.RFC3339))
+		}
+		fmt.Printf("Recipient [%s] Archived [%d]\n", a.RecipientHash(arg), len(l))
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,346 @@
+package archive
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Archive File Extensions
+const (
+	extMessage = ".eml.enc" // Encrypted Compressed Message
+	extTemp    = ".tmp"     // Message being Written
+)
+
+// File Format Version Marker
+var magic = []byte("OVA1")
+
+// Error Reading from an Archive that is not Configured
+var ErrNotConfigured = errors.New("[archive] Archive not Configured")
+
+// Message IDs Usable as File Names as Is
+var safeID = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Archived Message
+type Entry struct {
+	ID       string    // Queue Message ID
+	Archived time.Time // Time Message was Archived
+}
+
+// Encrypted Archive of Sent Messages
+//
+// Messages are stored under '<recipient hash>/<message id>.eml.enc', as the
+// MIME message gzip compressed and encrypted with AES-256-GCM (the message ID
+// is authenticated, so files can't be swapped). Recipient hashes are keyed with
+// the archive key, so addresses can't be recovered without it.
+type Archive struct {
+	dir       string        // Archive Directory
+	key       []byte        // Encryption Key
+	aead      cipher.AEAD   // AES-256-GCM
+	retention time.Duration // Time to Keep Messages
+	lock      sync.Mutex    // Serializes Writes and Pruning
+}
+
+// Open Archive (nil Archive if not Configured)
+func Open(c *config.Archive) (*Archive, error) {
+	// Is the Archive Enabled?
+	if c == nil { // NO
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(c.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(c.Path, 0700)
+	if err != nil {
+		log.Printf("[archive.Open] Failed to Create Archive [%s]", c.Path)
+		return nil, err
+	}
+
+	a := &Archive{
+		dir:       c.Path,
+		key:       key,
+		aead:      aead,
+		retention: time.Duration(c.Retention) * time.Second,
+	}
+
+	// Clear Expired Messages from Previous Runs
+	// NOTE: Failure to Prune is not Fatal (Retried Hourly)
+	count, err := a.Prune()
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Archive [%s] Pruned [%d] Messages", c.Path, count)
+	}
+
+	return a, nil
+}
+
+// RecipientHash Index Key for Recipient Address
+func (a *Archive) RecipientHash(to string) string {
+	if a == nil {
+		return ""
+	}
+
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(to))))
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// fileName Archive File Name for Message ID
+func fileName(id string) string {
+	// Can the ID be Used as Is?
+	if safeID.MatchString(id) && (len(id) <= 128) { // YES
+		return id + extMessage
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return "x" + hex.EncodeToString(sum[:16]) + extMessage
+}
+
+// Store Archive MIME Message Exactly as Sent (nil Archive Stores Nothing)
+func (a *Archive) Store(id string, to string, mime []byte) error {
+	// Is the Archive Enabled?
+	if a == nil { // NO
+		return nil
+	}
+
+	return a.Put(id, to, mime)
+}
+
+// Put Archive MIME Message
+func (a *Archive) Put(id string, to string, mime []byte) error {
+	// Compress
+	var zipped bytes.Buffer
+	z := gzip.NewWriter(&zipped)
+	_, err := z.Write(mime)
+	if e := z.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	// Encrypt (Nonce Precedes Cipher Text)
+	nonce := make([]byte, a.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	b := append(append([]byte{}, magic...), nonce...)
+	b = a.aead.Seal(b, nonce, zipped.Bytes(), []byte(id))
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	dir := filepath.Join(a.dir, a.RecipientHash(to))
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "*"+extTemp)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, fileName(id)))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// decrypt Decrypt and Decompress Archive File
+func (a *Archive) decrypt(id string, b []byte) ([]byte, error) {
+	n := len(magic) + a.aead.NonceSize()
+	if (len(b) < n) || !bytes.Equal(b[:len(magic)], magic) {
+		return nil, errors.New("[archive] Not an Archived Message")
+	}
+
+	zipped, err := a.aead.Open(nil, b[len(magic):n], b[n:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("[archive] Failed to Decrypt Message [%s] (wrong key?)", id)
+	}
+
+	z, err := gzip.NewReader(bytes.NewReader(zipped))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	return io.ReadAll(z)
+}
+
+// Get Decrypted MIME Message for Message ID (nil if not Archived)
+func (a *Archive) Get(id string) ([]byte, error) {
+	// Is Archiving Enabled?
+	if a == nil { // NO
+		return nil, ErrNotConfigured
+	}
+
+	paths, err := filepath.Glob(filepath.Join(a.dir, "*", fileName(id)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Was the Message Archived?
+	if len(paths) == 0 { // NO
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return a.decrypt(id, b)
+}
+
+// Find Messages Archived for Recipient (Most Recent First)
+//
+// IDs that could not be used as file names are not recoverable from the index,
+// and are listed by their file name.
+func (a *Archive) Find(to string) ([]*Entry, error) {
+	// Is Archiving Enabled?
+	if a == nil { // NO
+		return nil, ErrNotConfigured
+	}
+
+	dir := filepath.Join(a.dir, a.RecipientHash(to))
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var l []*Entry
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), extMessage) {
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+
+		l = append(l, &Entry{
+			ID:       strings.TrimSuffix(f.Name(), extMessage),
+			Archived: info.ModTime(),
+		})
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Archived.After(l[j].Archived)
+	})
+	return l, nil
+}
+
+// Prune Remove Messages Archived before Retention Period
+func (a *Archive) Prune() (int, error) {
+	if a == nil {
+		return 0, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	dirs, err := os.ReadDir(a.dir)
+	if err != nil {
+		return 0, errors.New("[archive.Prune] " + err.Error())
+	}
+
+	count := 0
+	cutoff := time.Now().Add(-a.retention)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(a.dir, d.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		kept := 0
+		for _, f := range files {
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+
+			// Expired (or Left Over from an Interrupted Write)?
+			if info.ModTime().Before(cutoff) || (strings.HasSuffix(f.Name(), extTemp) && time.Since(info.ModTime()) > time.Hour) { // YES
+				if os.Remove(filepath.Join(dir, f.Name())) == nil {
+					if strings.HasSuffix(f.Name(), extMessage) {
+						count++
+					}
+					continue
+				}
+			}
+			kept++
+		}
+
+		// Remove Empty Recipient Directories
+		if kept == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,130 @@
+package archive
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+func open(t *testing.T, dir string, key byte) *Archive {
+	t.Helper()
+
+	a, err := Open(&config.Archive{
+		Path:      dir,
+		Key:       base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{key}, 32)),
+		Retention: 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	a := open(t, dir, 1)
+
+	mime := []byte("To: user@example.com\r\nSubject: Hello\r\n\r\nHello Ana\r\n")
+	for _, id := range []string{"m1", "../m2"} {
+		if err := a.Put(id, "User@Example.com", mime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stored Encrypted
+	files, _ := filepath.Glob(filepath.Join(dir, a.RecipientHash("user@example.com"), "*"+extMessage))
+	if len(files) != 2 {
+		t.Fatalf("Archived Files %v", files)
+	}
+	for _, f := range files {
+		b, _ := os.ReadFile(f)
+		if bytes.Contains(b, []byte("Hello")) {
+			t.Errorf("Message Stored in Clear [%s]", f)
+		}
+	}
+
+	for _, id := range []string{"m1", "../m2"} {
+		b, err := a.Get(id)
+		if (err != nil) || !bytes.Equal(b, mime) {
+			t.Errorf("Get [%s] Failed [%v] [%s]", id, err, b)
+		}
+	}
+
+	if b, err := a.Get("m3"); (b != nil) || (err != nil) {
+		t.Errorf("Unexpected Message [%v] [%s]", err, b)
+	}
+
+	if l, _ := a.Find("user@example.com"); len(l) != 2 {
+		t.Errorf("Expected 2 Messages for Recipient, Got %v", l)
+	}
+
+	if l, _ := a.Find("other@example.com"); len(l) != 0 {
+		t.Errorf("Unexpected Messages %v", l)
+	}
+
+	// Wrong Key Fails
+	if _, err := open(t, dir, 2).Get("m1"); err == nil {
+		t.Error("Decrypted with Wrong Key")
+	}
+}
+
+func TestArchivePrune(t *testing.T) {
+	dir := t.TempDir()
+	a := open(t, dir, 1)
+
+	a.Put("old", "user@example.com", []byte("old"))
+	a.Put("new", "user@example.com", []byte("new"))
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, a.RecipientHash("user@example.com"), "old"+extMessage), old, old)
+
+	if n, err := a.Prune(); (err != nil) || (n != 1) {
+		t.Fatalf("Pruned [%d] Expected [1] [%v]", n, err)
+	}
+
+	if b, _ := a.Get("old"); b != nil {
+		t.Error("Expired Message not Pruned")
+	}
+	if b, _ := a.Get("new"); string(b) != "new" {
+		t.Error("Message Pruned before Expiring")
+	}
+}
+
+func TestArchiveNotConfigured(t *testing.T) {
+	a, err := Open(nil)
+	if (err != nil) || (a != nil) {
+		t.Fatalf("Archive [%v] Error [%v]", a, err)
+	}
+
+	if err := a.Store("m1", "user@example.com", []byte("mime")); err != nil {
+		t.Errorf("Store Failed [%s]", err)
+	}
+
+	if _, err := a.Get("m1"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Get Error [%v] Expected [%s]", err, ErrNotConfigured)
+	}
+
+	if _, err := a.Find("user@example.com"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Find Error [%v] Expected [%s]", err, ErrNotConfigured)
+	}
+
+	if h := a.RecipientHash("user@example.com"); h != "" {
+		t.Errorf("Unexpected Hash [%s]", h)
+	}
+}
@@ -0,0 +1,149 @@
+package broker
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-interface/queue"
+)
+
+// AMQP Broker over an AMQP Server Connection
+//
+// NOTE: The connection caches channels in a map that is not thread safe, so
+// channels are only opened while holding the lock.
+type AMQP struct {
+	conn *queue.AMQPServerConnection // Open Server Connection
+	lock sync.Mutex                  // Serializes Opening Channels
+}
+
+// NewAMQP Broker using Connection (that has to be Open)
+func NewAMQP(conn *queue.AMQPServerConnection) *AMQP {
+	return &AMQP{conn: conn}
+}
+
+// queueName Full Queue Name (Including Prefix)
+func (a *AMQP) queueName(name string) string {
+	if a.conn.Prefix() == "" {
+		return name
+	}
+	return a.conn.Prefix() + "-" + name
+}
+
+func (a *AMQP) channel(name string) (*amqp.Channel, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.conn.OpenChannel(name)
+}
+
+func (a *AMQP) queueChannel(name string, queue string, create bool) (*amqp.Channel, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.conn.OpenQueueChannel(name, queue, create)
+}
+
+func (a *AMQP) QueueRetrieve(channel string, queue string) (*amqp.Delivery, error) {
+	ch, err := a.queueChannel(channel, queue, false)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, ok, err := ch.Get(a.queueName(queue), false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Is Queue Empty?
+	if !ok { // YES
+		return nil, nil
+	}
+
+	return &delivery, nil
+}
+
+func (a *AMQP) QueueDeclare(channel string, queue string) error {
+	_, err := a.queueChannel(channel, queue, true)
+	return err
+}
+
+func (a *AMQP) QueuePublish(channel string, queue string, msg amqp.Publishing) error {
+	return a.Publish(channel, "", a.queueName(queue), msg)
+}
+
+func (a *AMQP) ExchangeDeclare(channel string, exchange string) error {
+	ch, err := a.channel(channel)
+	if err != nil {
+		return err
+	}
+
+	return ch.ExchangeDeclare(
+		exchange, // name
+		"topic",  // type
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	)
+}
+
+func (a *AMQP) QueueBind(channel string, queue string, key string, exchange string) error {
+	ch, err := a.channel(channel)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(a.queueName(queue), key, exchange, false, nil)
+}
+
+func (a *AMQP) Publish(channel string, exchange string, key string, msg amqp.Publishing) error {
+	ch, err := a.channel(channel)
+	if err != nil {
+		return err
+	}
+
+	return ch.Publish(
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
+		msg)
+}
+
+func (a *AMQP) NotifyClose(channel string, queue string) (<-chan *amqp.Error, error) {
+	a.lock.Lock()
+	conn, err := a.conn.OpenConnection()
+	a.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := a.queueChannel(channel, queue, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: amqp Closes Notification Channels, Buffered so Close Never Blocks
+	closed := make(chan *amqp.Error, 2)
+	forward := func(n chan *amqp.Error) {
+		e, ok := <-n
+		if !ok {
+			e = amqp.ErrClosed
+		}
+		closed <- e
+	}
+
+	go forward(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	go forward(ch.NotifyClose(make(chan *amqp.Error, 1)))
+	return closed, nil
+}
@@ -0,0 +1,42 @@
+package broker
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"github.com/streadway/amqp"
+)
+
+// Queue Server Operations used while Polling
+//
+// Queue names are given without the configured prefix. Channels are identified
+// by name, and opened on first use.
+type Broker interface {
+	// QueueRetrieve Get Next Message from Queue (nil if Queue is Empty)
+	QueueRetrieve(channel string, queue string) (*amqp.Delivery, error)
+
+	// QueueDeclare Make Sure (Durable) Queue Exists
+	QueueDeclare(channel string, queue string) error
+
+	// QueuePublish Publish Message to Queue (through the Default Exchange)
+	QueuePublish(channel string, queue string, msg amqp.Publishing) error
+
+	// ExchangeDeclare Make Sure (Durable Topic) Exchange Exists
+	ExchangeDeclare(channel string, exchange string) error
+
+	// QueueBind Route Exchange Messages Matching Key to Queue
+	QueueBind(channel string, queue string, key string, exchange string) error
+
+	// Publish Publish Message to Exchange with Routing Key
+	Publish(channel string, exchange string, key string, msg amqp.Publishing) error
+
+	// NotifyClose Signaled once when either the Connection or the Channel Closes
+	NotifyClose(channel string, queue string) (<-chan *amqp.Error, error)
+}
@@ -11,14 +11,17 @@ package config
  */
 
 import (
-	"encoding/json"
+	"encoding/base64"
 	"errors"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/objectvault/queue-interface/shared"
+	"github.com/objectvault/queue-smtp-mailer/window"
 )
 
 type Paths struct {
@@ -32,9 +35,15 @@ type Authentication struct {
 	Password string `json:"password,omitempty"` // User Password
 }
 
+type RateLimit struct {
+	Rate  float64 `json:"rate"`            // Messages per Minute
+	Burst int     `json:"burst,omitempty"` // Messages Sent without Waiting (DEFAULT 1)
+}
+
 type SMTPRelay struct {
 	Server         *shared.Server  `json:"server,omitempty"`         // Email Relay Server
 	Authentication *Authentication `json:"authentication,omitempty"` // Email Relay Server
+	RateLimit      *RateLimit      `json:"rate-limit,omitempty"`     // Relay Send Quota
 }
 
 type Retries struct {
@@ -42,52 +51,208 @@ type Retries struct {
 	RetriesInterval int `json:"retry-interval,omitempty"` // Seconds Between Retries (DEFAULT 60 seconds)
 }
 
+type Events struct {
+	Exchange   string `json:"exchange,omitempty"`    // Exchange to Publish Status Events (DEFAULT Queue Default Exchange)
+	Queue      string `json:"queue,omitempty"`       // Queue to Publish Status Events
+	RoutingKey string `json:"routing-key,omitempty"` // Routing Key Prefix for Exchange (DEFAULT "mailer")
+}
+
+type DeadLetter struct {
+	Queue string `json:"queue"` // Queue to Park Rejected and Failed Messages
+}
+
+type Spool struct {
+	Path             string `json:"path,omitempty"`               // Spool Directory (DEFAULT {tmp}/spool)
+	RetryInterval    int    `json:"retry-interval,omitempty"`     // Seconds Before First Retry, Doubled on Each Retry (DEFAULT 60 seconds)
+	RetryMaxInterval int    `json:"retry-max-interval,omitempty"` // Maximum Seconds Between Retries (DEFAULT 3600 seconds)
+	MaxAttempts      int    `json:"max-attempts,omitempty"`       // Send Attempts before Message Fails (0 - No Limit)
+}
+
+// Request Source Types
+const (
+	SourceAMQP      = "amqp"      // AMQP Queue (options.poll-queue)
+	SourceDirectory = "directory" // Directory of JSON Request Files
+)
+
+type Source struct {
+	Type string `json:"type,omitempty"` // Source Type (DEFAULT "amqp")
+	Path string `json:"path,omitempty"` // Request Directory ("directory" Source)
+}
+
+type HTTP struct {
+	Listen string   `json:"listen"`           // Listen Address (i.e. ":8080")
+	Tokens []string `json:"tokens,omitempty"` // Accepted Bearer Tokens
+	Cert   string   `json:"cert,omitempty"`   // TLS Certificate File (DEFAULT No TLS)
+	Key    string   `json:"key,omitempty"`    // TLS Private Key File
+}
+
+type Deduplication struct {
+	Path string `json:"path,omitempty"` // Store File (DEFAULT {tmp}/dedup.db)
+	TTL  int    `json:"ttl,omitempty"`  // Seconds to Remember Sent Messages (DEFAULT 86400 seconds)
+}
+
+type Status struct {
+	Path      string `json:"path,omitempty"`      // Store File (DEFAULT {tmp}/status.db)
+	Retention int    `json:"retention,omitempty"` // Seconds to Keep Records after Last Update (DEFAULT 604800 seconds)
+}
+
+type Archive struct {
+	Path      string `json:"path,omitempty"`      // Archive Directory (DEFAULT {output}/archive)
+	Key       string `json:"encryption-key"`      // Encryption Key (Base64 Encoded 32 Bytes - AES-256)
+	Retention int    `json:"retention,omitempty"` // Seconds to Keep Archived Messages (DEFAULT 31536000 seconds)
+}
+
+type DeliveryWindow struct {
+	Start    string `json:"start"`              // Earliest Local Send Time (HH:MM)
+	End      string `json:"end"`                // Latest Local Send Time (HH:MM, Before Start Wraps Midnight)
+	Timezone string `json:"timezone,omitempty"` // Timezone if Request has None (DEFAULT UTC)
+}
+
+type RateLimits struct {
+	Global  *RateLimit            `json:"global,omitempty"`   // Limit for All Messages
+	Domains map[string]*RateLimit `json:"domains,omitempty"`  // Limit per Recipient Domain ("*" Any Other Domain)
+	MaxWait int                   `json:"max-wait,omitempty"` // Seconds to Wait for a Slot before Deferring (DEFAULT 60 seconds)
+}
+
+type Suppression struct {
+	Path   string   `json:"path,omitempty"`   // Suppression List File (DEFAULT {tmp}/suppressed.list)
+	Exempt []string `json:"exempt,omitempty"` // Templates Sent even to Suppressed Addresses
+}
+
+type Validation struct {
+	CheckMX  bool   `json:"check-mx,omitempty"` // Verify Recipient Domains Accept Mail
+	Resolver string `json:"resolver,omitempty"` // DNS Server (host:port) for MX Lookups (DEFAULT System Resolver)
+	Timeout  int    `json:"timeout,omitempty"`  // Seconds to Wait for DNS Lookups (DEFAULT 5 seconds)
+}
+
 type Options struct {
-	ConnectionRetriesMax    int    `json:"conn-max-retries,omitempty"`    // Limit of Retry Attempts (0 - No Limit)
-	ConnectionRetryInterval int    `json:"conn-retry-interval,omitempty"` // Seconds Between Retries (DEFAULT 60 seconds)
-	PollMaxMessages         int    `json:"poll-max-messages,omitempty"`   // Maximum Messages Processed per Poll (DEFAULT 10 seconds)
-	PollInterval            int    `json:"poll-interval,omitempty"`       // Seconds Between Poll (DEFAULT 10 seconds)
-	PollQueue               string `json:"poll-queue,omitempty"`          // Name of Incoming Queue
+	ConnectionRetriesMax       int    `json:"conn-max-retries,omitempty"`        // Limit of Retry Attempts (0 - No Limit)
+	ConnectionRetryInterval    int    `json:"conn-retry-interval,omitempty"`     // Seconds Before First Retry, Doubled on Each Retry (DEFAULT 60 seconds)
+	ConnectionRetryMaxInterval int    `json:"conn-retry-max-interval,omitempty"` // Maximum Seconds Between Retries (DEFAULT 600 seconds)
+	PollMaxMessages            int    `json:"poll-max-messages,omitempty"`       // Maximum Messages Processed per Poll (DEFAULT 10 seconds)
+	PollInterval               int    `json:"poll-interval,omitempty"`           // Seconds Between Poll (DEFAULT 10 seconds)
+	PollQueue                  string `json:"poll-queue,omitempty"`              // Name of Incoming Queue
+	ShutdownGrace              int    `json:"shutdown-grace,omitempty"`          // Seconds to Wait for In-Flight Messages on Shutdown (DEFAULT 30 seconds)
 }
 
 type DaemonConfig struct {
-	Queue     *shared.Queue `json:"queue,omitempty"`   // List of AMQP Servers
-	SMTPRelay *SMTPRelay    `json:"relay,omitempty"`   // Email Relay Server
-	Paths     *Paths        `json:"paths,omitempty"`   // Paths to Use
-	Options   *Options      `json:"options,omitempty"` // Server Options
+	Strict      bool                       `json:"strict,omitempty"`           // Reject Unknown Settings
+	Source      *Source                    `json:"source,omitempty"`           // Where Requests are Read from (DEFAULT AMQP Queue)
+	Queue       *shared.Queue              `json:"queue,omitempty"`            // List of AMQP Servers
+	SMTPRelay   *SMTPRelay                 `json:"relay,omitempty"`            // Email Relay Server
+	Paths       *Paths                     `json:"paths,omitempty"`            // Paths to Use
+	Options     *Options                   `json:"options,omitempty"`          // Server Options
+	HTTP        *HTTP                      `json:"http,omitempty"`             // Request Submission API
+	Events      *Events                    `json:"events,omitempty"`           // Delivery Status Events
+	DeadLetter  *DeadLetter                `json:"dead-letter,omitempty"`      // Rejected and Failed Messages
+	Dedup       *Deduplication             `json:"dedup,omitempty"`            // Duplicate Delivery Protection
+	Spool       *Spool                     `json:"spool,omitempty"`            // Local Outbox (Messages Acknowledged once Spooled)
+	Scheduler   *Spool                     `json:"scheduler,omitempty"`        // Messages Held until Due ('send_at' or Delivery Window)
+	Status      *Status                    `json:"status,omitempty"`           // Message Lifecycle Store
+	Archive     *Archive                   `json:"archive,omitempty"`          // Encrypted Copies of Sent Messages
+	RateLimits  *RateLimits                `json:"rate-limits,omitempty"`      // Outbound Send Limits
+	Windows     map[string]*DeliveryWindow `json:"delivery-windows,omitempty"` // Templates only Sent within Local Time Window (Others Sent Immediately)
+	Suppression *Suppression               `json:"suppression,omitempty"`      // Addresses not to Send to
+	Validation  *Validation                `json:"validation,omitempty"`       // Recipient Address Validation
+}
+
+// spoolDefaults Set Spool Defaults, Directory Named dir under tmp (false if no Path can be Set)
+func spoolDefaults(s *Spool, tmp string, dir string) bool {
+	// Do we have a Spool Directory?
+	if s.Path == "" { // NO: Use Temporary Directory
+		if tmp == "" {
+			return false
+		}
+		s.Path = filepath.Join(tmp, dir)
+	}
+
+	// Do we have a Valid Retry Interval?
+	if s.RetryInterval <= 0 { // NO: Set Default 60 seconds
+		s.RetryInterval = 60
+	}
+
+	// Do we have a Valid Retry Interval Cap?
+	if s.RetryMaxInterval < s.RetryInterval { // NO: Set Default 1 Hour
+		s.RetryMaxInterval = 3600
+		if s.RetryMaxInterval < s.RetryInterval {
+			s.RetryMaxInterval = s.RetryInterval
+		}
+	}
+
+	// Do we have a Valid Attempts Limit?
+	if s.MaxAttempts < 0 { // NO: No Limit
+		s.MaxAttempts = 0
+	}
+	return true
 }
 
-// Config CONTAINER for Daemon CONFIGURATION
-var serverConfig *DaemonConfig
+// Config CONTAINER for Daemon CONFIGURATION (Swapped Atomically on Reload)
+var serverConfig atomic.Value
 
+// Config Current Daemon Configuration (nil if not Set)
 func Config() *DaemonConfig {
-	return serverConfig
+	c, _ := serverConfig.Load().(*DaemonConfig)
+	return c
+}
+
+// Set Make Configuration Current
+func Set(c *DaemonConfig) {
+	serverConfig.Store(c)
 }
 
 // Load Configuration File
 func Load(path string) (*DaemonConfig, error) {
 	var config DaemonConfig
 
-	// Open Configuration File
-	file, errFile := os.Open(path)
+	// Read Configuration File (and Included Fragments)
+	settings, errFile := loadSettings(path, map[string]bool{})
 	if errFile != nil {
 		log.Printf("Error [%s]", errFile)
-		return nil, errors.New("ERROR: Configuration File Required")
-	}
 
-	// Close File After (Try) Loading
-	defer file.Close()
+		// Does the File Exist?
+		if _, err := os.Stat(path); err != nil { // NO
+			return nil, errors.New("ERROR: Configuration File Required")
+		}
+		return nil, errors.New("ERROR: Invalid Configuration File")
+	}
 
-	// Decode JSON
-	decoder := json.NewDecoder(file)
-	errDecoder := decoder.Decode(&config)
+	// Decode Settings
+	errDecoder := decodeSettings(settings, &config)
 	if errDecoder != nil {
-		log.Printf("JSON Parse Error [%s]\n", errDecoder)
+		log.Printf("Configuration Parse Error [%s]\n", errDecoder)
+		return nil, errors.New("ERROR: Invalid Configuration File")
+	}
+
+	// Apply Environment Overrides
+	err := applyEnvironment(&config)
+	if err != nil {
+		log.Printf("Environment Override Error %s", err)
+		return nil, errors.New("ERROR: Invalid Environment Override")
+	}
+
+	// Do we have a Request Source?
+	if config.Source == nil { // NO: Default to AMQP Queue
+		config.Source = &Source{Type: SourceAMQP}
+	}
+
+	switch config.Source.Type {
+	case "":
+		config.Source.Type = SourceAMQP
+	case SourceAMQP:
+	case SourceDirectory:
+		// Do we have a Request Directory?
+		if config.Source.Path == "" { // NO: Abort
+			log.Print("Directory Source requires a Path")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+	default:
+		log.Printf("Unknown Source Type [%s]", config.Source.Type)
 		return nil, errors.New("ERROR: Invalid Configuration File")
 	}
 
 	// Do we have AMQP Host Addresses?
-	if (config.Queue == nil) || len(config.Queue.Servers) == 0 { // NO: Abort
+	reading := config.Source.Type == SourceAMQP
+	if reading && ((config.Queue == nil) || len(config.Queue.Servers) == 0) { // NO: Abort
 		log.Print("No Queue Server Connection Information")
 		return nil, errors.New("ERROR: Invalid Configuration File")
 	}
@@ -129,7 +294,7 @@ func Load(path string) (*DaemonConfig, error) {
 		return nil, errors.New("ERROR: Invalid Configuration File")
 	} else {
 		// Do we have a Valid Queue Name?
-		if config.Options.PollQueue == "" { // NO: Abort
+		if reading && (config.Options.PollQueue == "") { // NO: Abort
 			log.Print("No Message Queue Name set in Configuration File")
 			return nil, errors.New("ERROR: Invalid Configuration File")
 		}
@@ -139,6 +304,14 @@ func Load(path string) (*DaemonConfig, error) {
 			config.Options.ConnectionRetryInterval = 60
 		}
 
+		// Do we have a Valid Retry Interval Cap?
+		if config.Options.ConnectionRetryMaxInterval < config.Options.ConnectionRetryInterval { // NO: Set Default 600 seconds
+			config.Options.ConnectionRetryMaxInterval = 600
+			if config.Options.ConnectionRetryMaxInterval < config.Options.ConnectionRetryInterval {
+				config.Options.ConnectionRetryMaxInterval = config.Options.ConnectionRetryInterval
+			}
+		}
+
 		// Does the Poller Have a Max Messages Limit?
 		if config.Options.PollMaxMessages <= 1 { // NO: Set Default 10 Messages
 			config.Options.PollMaxMessages = 10
@@ -148,6 +321,196 @@ func Load(path string) (*DaemonConfig, error) {
 		if config.Options.PollInterval <= 0 { // NO: Set Default 10 seconds
 			config.Options.PollInterval = 10
 		}
+
+		// Do we have a Shutdown Grace Period?
+		if config.Options.ShutdownGrace <= 0 { // NO: Set Default 30 seconds
+			config.Options.ShutdownGrace = 30
+		}
+	}
+
+	// Are Status Events or Dead Letters Configured without a Queue Server?
+	if !reading && ((config.Events != nil) || (config.DeadLetter != nil)) { // YES: Ignore them
+		log.Print("WARNING: Status Events and Dead Letter Queue require the AMQP Source")
+		config.Events, config.DeadLetter = nil, nil
+	}
+
+	// Do we have Status Events Configuration?
+	if config.Events != nil { // YES: Need at least an Exchange or a Queue
+		if (config.Events.Exchange == "") && (config.Events.Queue == "") {
+			log.Print("Status Events require an Exchange or a Queue")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+	}
+
+	// Do we have Dead Letter Configuration?
+	if (config.DeadLetter != nil) && (config.DeadLetter.Queue == "") { // YES: But no Queue
+		log.Print("Dead Letter Configuration requires a Queue")
+		return nil, errors.New("ERROR: Invalid Configuration File")
+	}
+
+	// Do we have an HTTP API?
+	if config.HTTP != nil { // YES: Validate
+		if config.HTTP.Listen == "" {
+			log.Print("HTTP API requires a Listen Address")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		// Can Clients Authenticate?
+		if len(config.HTTP.Tokens) == 0 { // NO: Abort
+			log.Print("HTTP API requires at least one Token")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		if (config.HTTP.Cert == "") != (config.HTTP.Key == "") {
+			log.Print("HTTP API TLS requires both a Certificate and a Key")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+	}
+
+	// Do we have Deduplication Configuration?
+	if config.Dedup != nil { // YES: Validate
+		// Do we have a Store Path?
+		if config.Dedup.Path == "" { // NO: Use Temporary Directory
+			if config.Paths.Temporary == "" {
+				log.Print("Deduplication requires a Store Path or Temporary Directory")
+				return nil, errors.New("ERROR: Invalid Configuration File")
+			}
+			config.Dedup.Path = filepath.Join(config.Paths.Temporary, "dedup.db")
+		}
+
+		// Do we have a Valid TTL?
+		if config.Dedup.TTL <= 0 { // NO: Set Default 1 Day
+			config.Dedup.TTL = 86400
+		}
+	}
+
+	// Do we have Spool Configuration?
+	if config.Spool != nil { // YES: Validate
+		if !spoolDefaults(config.Spool, config.Paths.Temporary, "spool") {
+			log.Print("Spool requires a Path or Temporary Directory")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+	}
+
+	// Do we have Scheduler Configuration?
+	if config.Scheduler != nil { // YES: Validate
+		if !spoolDefaults(config.Scheduler, config.Paths.Temporary, "scheduled") {
+			log.Print("Scheduler requires a Path or Temporary Directory")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+	}
+
+	// Validate Delivery Windows
+	for template, w := range config.Windows {
+		// Can Messages be Held until the Window Opens?
+		if config.Scheduler == nil { // NO: Abort
+			log.Print("Delivery Windows require a Scheduler")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		if w == nil {
+			log.Printf("Delivery Window for Template [%s] is Empty", template)
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		_, err := window.Parse(w.Start, w.End)
+		if err != nil {
+			log.Printf("Delivery Window for Template [%s]: %s", template, err)
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		_, err = time.LoadLocation(w.Timezone)
+		if err != nil {
+			log.Printf("Delivery Window for Template [%s]: Invalid Timezone [%s]", template, w.Timezone)
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+	}
+
+	// Do we have Status Store Configuration?
+	if config.Status != nil { // YES: Validate
+		// Do we have a Store Path?
+		if config.Status.Path == "" { // NO: Use Temporary Directory
+			if config.Paths.Temporary == "" {
+				log.Print("Status Store requires a Path or Temporary Directory")
+				return nil, errors.New("ERROR: Invalid Configuration File")
+			}
+			config.Status.Path = filepath.Join(config.Paths.Temporary, "status.db")
+		}
+
+		// Do we have a Valid Retention Period?
+		if config.Status.Retention <= 0 { // NO: Set Default 7 Days
+			config.Status.Retention = 604800
+		}
+	}
+
+	// Do we have Archive Configuration?
+	if config.Archive != nil { // YES: Validate
+		// Do we have an Archive Directory?
+		if config.Archive.Path == "" { // NO: Use Output Directory
+			if config.Paths.Output == "" {
+				log.Print("Archive requires a Path or Output Directory")
+				return nil, errors.New("ERROR: Invalid Configuration File")
+			}
+			config.Archive.Path = filepath.Join(config.Paths.Output, "archive")
+		}
+
+		// Do we have a Valid Key?
+		key, err := base64.StdEncoding.DecodeString(config.Archive.Key)
+		if (err != nil) || (len(key) != 32) { // NO: Abort
+			log.Print("Archive requires a Base64 Encoded 32 Byte Key")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		// Do we have a Valid Retention Period?
+		if config.Archive.Retention <= 0 { // NO: Set Default 1 Year
+			config.Archive.Retention = 31536000
+		}
+	}
+
+	// Do we have Suppression List Configuration?
+	if config.Suppression != nil { // YES: Validate
+		// Do we have a List Path?
+		if config.Suppression.Path == "" { // NO: Use Temporary Directory
+			if config.Paths.Temporary == "" {
+				log.Print("Suppression List requires a Path or Temporary Directory")
+				return nil, errors.New("ERROR: Invalid Configuration File")
+			}
+			config.Suppression.Path = filepath.Join(config.Paths.Temporary, "suppressed.list")
+		}
+	}
+
+	// Do we have Address Validation Configuration?
+	if config.Validation != nil { // YES
+		// Do we have a Valid DNS Timeout?
+		if config.Validation.Timeout <= 0 { // NO: Set Default 5 seconds
+			config.Validation.Timeout = 5
+		}
+	}
+
+	// Is the Relay Rate Limited?
+	if !validRateLimit(config.SMTPRelay.RateLimit) { // YES: But Invalid
+		log.Print("Invalid SMTP Relay Rate Limit")
+		return nil, errors.New("ERROR: Invalid Configuration File")
+	}
+
+	// Do we have Rate Limits?
+	if config.RateLimits != nil { // YES: Validate
+		if !validRateLimit(config.RateLimits.Global) {
+			log.Print("Invalid Global Rate Limit")
+			return nil, errors.New("ERROR: Invalid Configuration File")
+		}
+
+		for d, r := range config.RateLimits.Domains {
+			if (r == nil) || !validRateLimit(r) {
+				log.Printf("Invalid Rate Limit for Domain [%s]", d)
+				return nil, errors.New("ERROR: Invalid Configuration File")
+			}
+		}
+
+		// Do we have a Valid Max Wait?
+		if config.RateLimits.MaxWait <= 0 { // NO: Set Default 60 seconds
+			config.RateLimits.MaxWait = 60
+		}
 	}
 
 	// Convert Path to Full Path Name
@@ -158,6 +521,15 @@ func Load(path string) (*DaemonConfig, error) {
 	return &config, nil
 }
 
+func validRateLimit(r *RateLimit) bool {
+	// Limit Set?
+	if r == nil { // NO: Nothing to Validate
+		return true
+	}
+
+	return r.Rate > 0
+}
+
 func getChildProperty(source map[string]interface{}, elements []string, i int, dvalue interface{}) interface{} {
 	if i >= len(elements) {
 		return source
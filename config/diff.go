@@ -0,0 +1,166 @@
+package config
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Settings Applied on Reload (Everything Else Requires a Restart)
+var reloadable = []string{
+	"relay.server",
+	"relay.authentication",
+	"relay.rate-limit",
+	"rate-limits",
+	"paths.templates",
+	"options.poll-max-messages",
+	"options.poll-interval",
+	"options.shutdown-grace",
+	"http.tokens",
+	"delivery-windows",
+	"spool.retry-interval",
+	"spool.retry-max-interval",
+	"spool.max-attempts",
+	"scheduler.retry-interval",
+	"scheduler.retry-max-interval",
+	"scheduler.max-attempts",
+}
+
+// Change Single Setting Changed between Configurations
+type Change struct {
+	Path     string // Dotted Path to Setting (i.e. relay.server.host)
+	Old      string // Previous Value ("" if not Set)
+	New      string // New Value ("" if Removed)
+	Reloaded bool   // Setting Applied without Restart?
+}
+
+func (c *Change) String() string {
+	s := fmt.Sprintf("%s: [%s] -> [%s]", c.Path, c.Old, c.New)
+	if !c.Reloaded {
+		s += " (requires restart)"
+	}
+	return s
+}
+
+// Settings whose Values must not be Logged (Last Path Segment)
+var secrets = map[string]bool{
+	"password":       true,
+	"tokens":         true,
+	"encryption-key": true,
+}
+
+// isSecret Values that must not be Logged
+func isSecret(path string) bool {
+	// List Elements are Secret if the List is (i.e. http.tokens[1])
+	if strings.HasSuffix(path, "]") {
+		if i := strings.LastIndex(path, "["); i > 0 {
+			path = path[:i]
+		}
+	}
+
+	return secrets[path[strings.LastIndex(path, ".")+1:]]
+}
+
+// flatten Convert Decoded JSON into Dotted Path Values
+func flatten(prefix string, v interface{}, out map[string]string) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			flatten(p, e, out)
+		}
+	case []interface{}:
+		for i, e := range x {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), e, out)
+		}
+	default:
+		out[prefix] = fmt.Sprint(x)
+	}
+}
+
+func settings(c *DaemonConfig) map[string]string {
+	out := map[string]string{}
+	if c == nil {
+		return out
+	}
+
+	// Use JSON Names so Paths Match the Configuration File
+	b, err := json.Marshal(c)
+	if err != nil {
+		return out
+	}
+
+	var v interface{}
+	json.Unmarshal(b, &v)
+	flatten("", v, out)
+	return out
+}
+
+// Diff Settings Changed from Old to New Configuration (Secrets Redacted)
+func Diff(old *DaemonConfig, new *DaemonConfig) []*Change {
+	before := settings(old)
+	after := settings(new)
+
+	// All Setting Paths (Sorted)
+	paths := map[string]bool{}
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var changes []*Change
+	for _, p := range sorted {
+		b, inBefore := before[p]
+		a, inAfter := after[p]
+
+		// Changed?
+		if (inBefore == inAfter) && (b == a) { // NO
+			continue
+		}
+
+		// Never Log Secrets
+		if isSecret(p) {
+			b, a = redact(b), redact(a)
+		}
+
+		c := &Change{Path: p, Old: b, New: a}
+		for _, r := range reloadable {
+			if (p == r) || strings.HasPrefix(p, r+".") || strings.HasPrefix(p, r+"[") {
+				c.Reloaded = true
+				break
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+func redact(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "*****"
+}
@@ -0,0 +1,153 @@
+package config
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/objectvault/queue-interface/shared"
+)
+
+// base Configuration with Secrets at Every Level
+func base() *DaemonConfig {
+	return &DaemonConfig{
+		Queue: &shared.Queue{Servers: []shared.AMQPConnection{
+			{User: "mailer", Password: "old-secret", Server: &shared.Server{Host: "mq1", Port: 5672}},
+		}},
+		SMTPRelay: &SMTPRelay{
+			Server:         &shared.Server{Host: "smtp", Port: 25},
+			Authentication: &Authentication{User: "relay", Password: "old-relay"},
+		},
+		Paths:   &Paths{Templates: "/templates"},
+		HTTP:    &HTTP{Listen: ":8080", Tokens: []string{"token-a", "token-b"}},
+		Archive: &Archive{Path: "/archive", Key: "old-key"},
+	}
+}
+
+// find Change for Path
+func find(changes []*Change, path string) *Change {
+	for _, c := range changes {
+		if c.Path == path {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestIsSecret(t *testing.T) {
+	tests := map[string]bool{
+		"password":                       true,
+		"tokens":                         true,
+		"queue.servers[0].password":      true,
+		"relay.authentication.password":  true,
+		"http.tokens":                    true,
+		"http.tokens[1]":                 true,
+		"archive.encryption-key":         true,
+		"queue.servers[0].user":          false,
+		"queue.servers[0].server.host":   false,
+		"http.key":                       false,
+		"relay.authentication.user":      false,
+		"events.routing-key":             false,
+		"delivery-windows.welcome.start": false,
+	}
+
+	for path, want := range tests {
+		if got := isSecret(path); got != want {
+			t.Errorf("isSecret [%s] [%v] Expected [%v]", path, got, want)
+		}
+	}
+}
+
+func TestDiffSecrets(t *testing.T) {
+	old, new := base(), base()
+	new.Queue.Servers[0].Password = "new-secret"
+	new.SMTPRelay.Authentication.Password = "new-relay"
+	new.HTTP.Tokens[1] = "token-c"
+	new.Archive.Key = "new-key"
+
+	changes := Diff(old, new)
+	if len(changes) != 4 {
+		t.Fatalf("Changes %v Expected [4]", changes)
+	}
+
+	for _, path := range []string{"queue.servers[0].password", "relay.authentication.password", "http.tokens[1]", "archive.encryption-key"} {
+		c := find(changes, path)
+		if c == nil {
+			t.Errorf("Change [%s] not Reported", path)
+			continue
+		}
+
+		if (c.Old != "*****") || (c.New != "*****") {
+			t.Errorf("Secret Logged [%s]", c)
+		}
+
+		for _, secret := range []string{"old-", "new-", "token-"} {
+			if strings.Contains(c.String(), secret) {
+				t.Errorf("Secret Logged [%s]", c)
+			}
+		}
+	}
+
+	// Reload Applies Tokens, but not Queue Credentials
+	if c := find(changes, "http.tokens[1]"); (c != nil) && !c.Reloaded {
+		t.Errorf("Change [%s] not Reloaded", c)
+	}
+	if c := find(changes, "queue.servers[0].password"); (c != nil) && c.Reloaded {
+		t.Errorf("Change [%s] Reloaded", c)
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	old, new := base(), base()
+	new.SMTPRelay.Server.Host = "smtp2"
+	new.HTTP.Tokens = append(new.HTTP.Tokens, "token-c")
+	new.HTTP.Listen = ":9090"
+
+	changes := Diff(old, new)
+	if len(changes) != 3 {
+		t.Fatalf("Changes %v Expected [3]", changes)
+	}
+
+	if c := find(changes, "relay.server.host"); (c == nil) || (c.Old != "smtp") || (c.New != "smtp2") || !c.Reloaded {
+		t.Errorf("Unexpected Change %v", c)
+	}
+
+	// Added List Element (Secret)
+	if c := find(changes, "http.tokens[2]"); (c == nil) || (c.Old != "") || (c.New != "*****") {
+		t.Errorf("Unexpected Change %v", c)
+	}
+
+	if c := find(changes, "http.listen"); (c == nil) || c.Reloaded || !strings.HasSuffix(c.String(), "(requires restart)") {
+		t.Errorf("Unexpected Change %v", c)
+	}
+
+	if changes := Diff(base(), base()); len(changes) != 0 {
+		t.Errorf("Unexpected Changes %v", changes)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	b, err := Redacted(base())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"old-secret", "old-relay", "token-a", "old-key"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("Secret [%s] not Redacted:\n%s", secret, b)
+		}
+	}
+
+	if !strings.Contains(string(b), `"mailer"`) {
+		t.Errorf("Setting Redacted:\n%s", b)
+	}
+}
@@ -0,0 +1,248 @@
+package config
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Environment Overrides
+//
+// Every setting can be overridden by an environment variable named after its
+// path in the configuration file, upper cased, with '.' and '-' replaced by
+// '_' and prefixed with MAILER_ (list elements use their index), i.e.:
+//
+//   relay.server.host          -> MAILER_RELAY_SERVER_HOST
+//   options.conn-max-retries   -> MAILER_OPTIONS_CONN_MAX_RETRIES
+//   queue.servers[0].password  -> MAILER_QUEUE_SERVERS_0_PASSWORD
+//
+// Any variable can instead be given as a path to a file containing the value,
+// by appending _FILE (i.e. MAILER_RELAY_AUTHENTICATION_PASSWORD_FILE), for use
+// with Docker/Kubernetes secrets.
+//
+// Precedence (highest first): VARIABLE, VARIABLE_FILE, configuration file.
+// Map settings (i.e. rate-limits.domains) can only be set in the file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Prefix for Environment Variable Names
+const EnvPrefix = "MAILER"
+
+// EnvName Environment Variable for Setting Path (i.e. relay.server.host)
+func EnvName(path string) string {
+	r := strings.NewReplacer(".", "_", "-", "_", "[", "_", "]", "")
+	return EnvPrefix + "_" + strings.ToUpper(r.Replace(path))
+}
+
+func environment() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, EnvPrefix+"_") {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+// envValue Value of Variable (or Contents of VARIABLE_FILE)
+func envValue(env map[string]string, name string) (string, bool, error) {
+	if v, ok := env[name]; ok {
+		return v, true, nil
+	}
+
+	path, ok := env[name+"_FILE"]
+	if !ok {
+		return "", false, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("[%s_FILE] %s", name, err)
+	}
+
+	// Secret Files Usually End with a New Line
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+// envHasPrefix Is any Variable Set Below Name?
+func envHasPrefix(env map[string]string, name string) bool {
+	for k := range env {
+		if strings.HasPrefix(k, name+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// envMaxIndex Highest List Index Set Below Name (-1 if None)
+func envMaxIndex(env map[string]string, name string) int {
+	max := -1
+	for k := range env {
+		if !strings.HasPrefix(k, name+"_") {
+			continue
+		}
+
+		rest := k[len(name)+1:]
+		if i := strings.Index(rest, "_"); i > 0 {
+			rest = rest[:i]
+		}
+
+		if n, err := strconv.Atoi(rest); (err == nil) && (n > max) {
+			max = n
+		}
+	}
+	return max
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func setScalar(v reflect.Value, name string, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return fmt.Errorf("[%s] Not an Integer [%s]", name, s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("[%s] Not a Number [%s]", name, s)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("[%s] Not a Boolean [%s]", name, s)
+		}
+		v.SetBool(b)
+	case reflect.Slice: // Comma Separated List of Strings
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+
+		var list []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	}
+
+	return nil
+}
+
+// override Apply Environment to Value (Struct, Pointer, List or Scalar)
+func override(v reflect.Value, name string, env map[string]string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		// Is Anything Set Below this Setting?
+		if v.IsNil() { // NO: Create it if Needed
+			if (v.Type().Elem().Kind() != reflect.Struct) || !envHasPrefix(env, name) {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return override(v.Elem(), name, env)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			n := jsonName(f)
+			if (n == "") || (f.PkgPath != "") { // Skip Unexported Fields
+				continue
+			}
+
+			err := override(v.Field(i), name+"_"+strings.ToUpper(strings.ReplaceAll(n, "-", "_")), env)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		// List of Settings?
+		if v.Type().Elem().Kind() == reflect.Struct { // YES: Grow List to Highest Index Set
+			if max := envMaxIndex(env, name); max >= v.Len() {
+				grown := reflect.MakeSlice(v.Type(), max+1, max+1)
+				reflect.Copy(grown, v)
+				v.Set(grown)
+			}
+
+			for i := 0; i < v.Len(); i++ {
+				err := override(v.Index(i), name+"_"+strconv.Itoa(i), env)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		fallthrough
+	default:
+		s, ok, err := envValue(env, name)
+		if err != nil || !ok {
+			return err
+		}
+		return setScalar(v, name, s)
+	}
+
+	return nil
+}
+
+// applyEnvironment Override Configuration with Environment Variables
+func applyEnvironment(c *DaemonConfig) error {
+	env := environment()
+	if len(env) == 0 {
+		return nil
+	}
+
+	return override(reflect.ValueOf(c).Elem(), EnvPrefix, env)
+}
+
+// Redacted Configuration as JSON with Secrets Hidden
+func Redacted(c *DaemonConfig) ([]byte, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	json.Unmarshal(b, &v)
+	redactSecrets(v)
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func redactSecrets(v interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			if isSecret(k) {
+				x[k] = redact(fmt.Sprint(e))
+				continue
+			}
+			redactSecrets(e)
+		}
+	case []interface{}:
+		for _, e := range x {
+			redactSecrets(e)
+		}
+	}
+}
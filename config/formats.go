@@ -0,0 +1,185 @@
+package config
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Configuration File Formats and Includes
+//
+// The format is selected by extension: .yaml/.yml (YAML), .toml (TOML),
+// anything else JSON. A file can list fragments to merge in its 'include'
+// setting (a path or list of paths, relative to the including file). Fragments
+// are merged in order, and the including file's own settings are applied
+// last, so they win. Objects are merged key by key, other values replaced.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// normalize Convert Decoded YAML/TOML into JSON Compatible Values
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = normalize(e)
+		}
+		return x
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range x {
+			x[i] = normalize(e)
+		}
+		return x
+	case []map[string]interface{}: // TOML Arrays of Tables
+		l := make([]interface{}, len(x))
+		for i, e := range x {
+			l[i] = normalize(e)
+		}
+		return l
+	}
+
+	return v
+}
+
+// readFile Decode Configuration File into Generic Settings Map
+func readFile(path string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &settings)
+	case ".toml":
+		err = toml.Unmarshal(b, &settings)
+	default:
+		d := json.NewDecoder(bytes.NewReader(b))
+		err = d.Decode(&settings)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %s", path, err)
+	}
+
+	// YAML: Empty Document
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+
+	return normalize(settings).(map[string]interface{}), nil
+}
+
+// merge Merge Settings from src into dst (src Wins)
+func merge(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		sm, srcIsMap := v.(map[string]interface{})
+		dm, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merge(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// includes List of Included Files (Relative to Including File)
+func includes(settings map[string]interface{}, base string) ([]string, error) {
+	var list []string
+	switch v := ConfigProperty(settings, "include", nil).(type) {
+	case nil:
+		return nil, nil
+	case string:
+		list = []string{v}
+	case []interface{}:
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid 'include' [%v]", e)
+			}
+			list = append(list, s)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid 'include' [%v]", v)
+	}
+
+	for i, p := range list {
+		if !filepath.IsAbs(p) {
+			list[i] = filepath.Join(filepath.Dir(base), p)
+		}
+	}
+	return list, nil
+}
+
+// loadSettings Read File and its Includes into Merged Settings Map
+func loadSettings(path string, loading map[string]bool) (map[string]interface{}, error) {
+	abs, _ := filepath.Abs(path)
+
+	// Is the File Including Itself?
+	if loading[abs] { // YES
+		return nil, fmt.Errorf("[%s] Include Cycle", path)
+	}
+	loading[abs] = true
+	defer delete(loading, abs)
+
+	settings, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := includes(settings, path)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %s", path, err)
+	}
+	delete(settings, "include")
+
+	// Merge Fragments in Order, then the File's Own Settings
+	merged := map[string]interface{}{}
+	for _, f := range files {
+		fragment, err := loadSettings(f, loading)
+		if err != nil {
+			return nil, err
+		}
+		merge(merged, fragment)
+	}
+	merge(merged, settings)
+
+	return merged, nil
+}
+
+// decodeSettings Decode Merged Settings into Configuration (Strict: Unknown Keys are Errors)
+func decodeSettings(settings map[string]interface{}, config *DaemonConfig) error {
+	strict, _ := ConfigProperty(settings, "strict", false).(bool)
+
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	if strict {
+		d.DisallowUnknownFields()
+	}
+
+	return d.Decode(config)
+}
@@ -11,68 +11,196 @@ package main
  */
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"time"
 
+	"github.com/objectvault/queue-interface/queue"
+	"github.com/objectvault/queue-interface/shared"
+	"github.com/objectvault/queue-smtp-mailer/broker"
 	"github.com/objectvault/queue-smtp-mailer/config"
 	"github.com/objectvault/queue-smtp-mailer/poller"
+	"github.com/objectvault/queue-smtp-mailer/source"
 )
 
-func connector(c *config.DaemonConfig) {
-	// After everything is Done Make Sure to Close Everything
-	defer func() {
-		if poller.Shutdown {
-			done <- true
+// Connection Health of an AMQP Server
+type serverHealth struct {
+	server   shared.AMQPConnection // Server Connection Settings
+	failures int                   // Sequential Failures (Connection Attempts or Drops)
+}
+
+func serverName(s *shared.AMQPConnection) string {
+	if s.Server == nil {
+		return "?"
+	}
+
+	if s.Server.Port == 0 {
+		return s.Server.Host
+	}
+	return fmt.Sprintf("%s:%d", s.Server.Host, s.Server.Port)
+}
+
+// connectAny Open Connection to the First Reachable Server
+func connectAny(mailerMQ *queue.AMQPServerConnection, servers []shared.AMQPConnection) error {
+	var err error
+	for i := range servers {
+		mailerMQ.SetConnection(servers[i : i+1])
+		_, err = mailerMQ.OpenConnection()
+		if err == nil {
+			return nil
+		}
+		log.Printf("Connection to [%s] Failed [%s]", serverName(&servers[i]), err)
+	}
+
+	return err
+}
+
+// nextServer Pick Server to Try (Last if Healthy, Else Fewest Sequential Failures, Rotating on Ties)
+func nextServer(servers []*serverHealth, last int) int {
+	// Is the Last Server Healthy?
+	if servers[last].failures == 0 { // YES: Stay on it
+		return last
+	}
+
+	best := -1
+	for i := 1; i <= len(servers); i++ {
+		j := (last + i) % len(servers)
+		if (best < 0) || (servers[j].failures < servers[best].failures) {
+			best = j
 		}
-	}()
+	}
 
+	return best
+}
+
+// backoff Exponential Backoff with Equal Jitter (Half Fixed, Half Random) Capped at max
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	d := base
+	for i := 1; (i < attempt) && (d < max); i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Sessions Shorter than this are Counted as Failed Connections
+const minSession = 30 * time.Second
+
+// ended Track Session that Lasted d (Returns true if it was Healthy)
+func (h *serverHealth) ended(d time.Duration) bool {
+	// Did the Session Last?
+	if d >= minSession { // YES
+		h.failures = 0
+		return true
+	}
+
+	// NO: Prefer Another Server on Reconnect
+	h.failures++
+	return false
+}
+
+// connector Keep Connection to Queue Open and Poll it until Context is Cancelled
+//
+// Returns an error if the connection retry limit is exceeded.
+func connector(ctx context.Context, c *config.DaemonConfig, mailerMQ *queue.AMQPServerConnection, s *poller.Services) error {
 	// Control Settings
 	maxRetries := c.Options.ConnectionRetriesMax
-	interval := time.Duration(c.Options.ConnectionRetryInterval*1000) * time.Millisecond
+	interval := time.Duration(c.Options.ConnectionRetryInterval) * time.Second
+	maxInterval := time.Duration(c.Options.ConnectionRetryMaxInterval) * time.Second
+
+	// Server Health
+	servers := make([]*serverHealth, len(c.Queue.Servers))
+	for i := range c.Queue.Servers {
+		servers[i] = &serverHealth{server: c.Queue.Servers[i]}
+	}
+	current := 0
 
 	// Number of Sequential Errors
 	errorCount := 0
 
 	log.Print("START: Connection Poller")
 	log.Printf("Max Retries [%d]", maxRetries)
-	log.Printf("POLL Interval [%d]s", c.Options.ConnectionRetryInterval)
-	log.Print("START: Connection Poller")
+	log.Printf("Retry Interval [%d]s to [%d]s", c.Options.ConnectionRetryInterval, c.Options.ConnectionRetryMaxInterval)
+	log.Printf("Servers [%d]", len(servers))
 
 	// ENDLESS Loop
 	for {
-		// Log Retry
-		log.Printf("Retry [%d] of [%d]", errorCount+1, maxRetries)
-
 		// Do we Want to Stop the Poller?
-		if poller.Shutdown { // YES: Break Out of Loop
+		if ctx.Err() != nil { // YES: Break Out of Loop
 			log.Print("Stopping Connection Poller...")
 			break
 		}
 
-		// Increment Error Counter
-		errorCount++
+		// Select Server to Connect to
+		current = nextServer(servers, current)
+		server := servers[current]
+		mailerMQ.SetConnection([]shared.AMQPConnection{server.server})
+
+		// Log Attempt
+		if errorCount == 0 {
+			log.Printf("Connecting to [%s]", serverName(&server.server))
+		} else {
+			log.Printf("Connecting to [%s] Retry [%d] of [%d]", serverName(&server.server), errorCount, maxRetries)
+		}
 
 		// Do we have a Connection?
 		_, err := mailerMQ.OpenConnection()
 		if err == nil { // YES: Start Message Poller
-			errorCount = 0 // Reset Error Count
-			poller.Poller(c, mailerMQ)
+			started := time.Now()
+
+			// Read Poll Queue
+			b := broker.NewAMQP(mailerMQ)
+			src, err := source.NewAMQP(b, c.Options.PollQueue)
+			if err == nil {
+				poller.Poller(ctx, c, src, b, s)
+			} else {
+				log.Printf("Failed to Open Queue [%s] [%s]", c.Options.PollQueue, err)
+			}
 
 			// Poller Stopped - Presume Bad Connection - Reset it
 			mailerMQ.CloseConnection()
+
+			// Did the Session Last?
+			healthy := server.ended(time.Since(started))
+			if (ctx.Err() != nil) || healthy { // YES: Reconnect Immediately
+				errorCount = 0 // Reset Error Count
+				continue
+			}
+
+			// NO: Poller could not Start - Count it as a Failed Connection
+			log.Printf("Session with [%s] Ended after [%s]", serverName(&server.server), time.Since(started).Round(time.Millisecond))
+			errorCount++
+		} else {
+			// Track Server Health
+			log.Printf("Connection to [%s] Failed [%s]", serverName(&server.server), err)
+			server.failures++
+			errorCount++
 		}
 
 		// Did we exceed retry count?
 		if (maxRetries > 0) && (errorCount > maxRetries) { // YES: Shutdown the Server
-			poller.Shutdown = true
-			break
+			log.Print("STOP: Connection Poller")
+			return errors.New("[connector] Exceeded Connection Retries")
 		}
 		// ELSE: NO
 
 		// Sleep and Retry Connection
-		log.Print("Sleeping...")
-		time.Sleep(interval)
+		wait := backoff(errorCount, interval, maxInterval)
+		log.Printf("Sleeping [%s]...", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
 	}
 
 	log.Print("STOP: Connection Poller")
+	return nil
 }
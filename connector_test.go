@@ -0,0 +1,76 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerRotation(t *testing.T) {
+	servers := []*serverHealth{{}, {}, {}}
+
+	// Healthy Server is Kept
+	current := nextServer(servers, 0)
+	if current != 0 {
+		t.Fatalf("Server [%d] Expected [0]", current)
+	}
+
+	// Short Sessions and Failed Connections Move to the Next Server
+	if servers[current].ended(time.Second) {
+		t.Fatal("Short Session Healthy")
+	}
+	if current = nextServer(servers, current); current != 1 {
+		t.Fatalf("Server [%d] Expected [1]", current)
+	}
+
+	servers[current].failures++
+	if current = nextServer(servers, current); current != 2 {
+		t.Fatalf("Server [%d] Expected [2]", current)
+	}
+
+	// All Failing: Rotate
+	servers[current].failures++
+	if current = nextServer(servers, current); current != 0 {
+		t.Fatalf("Server [%d] Expected [0]", current)
+	}
+
+	// Fewest Failures Wins
+	servers[0].failures = 3
+	if current = nextServer(servers, current); current != 1 {
+		t.Fatalf("Server [%d] Expected [1]", current)
+	}
+
+	// Healthy Session Resets Failures and Stays on the Server
+	if !servers[current].ended(minSession) {
+		t.Fatal("Long Session not Healthy")
+	}
+	if servers[current].failures != 0 {
+		t.Fatalf("Failures [%d] not Reset", servers[current].failures)
+	}
+	for i := 0; i < 3; i++ {
+		if next := nextServer(servers, current); next != current {
+			t.Fatalf("Moved from Healthy Server [%d] to [%d]", current, next)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := time.Second, 8*time.Second
+
+	for attempt, want := range map[int]time.Duration{1: base, 2: 2 * base, 4: max, 10: max} {
+		for i := 0; i < 20; i++ {
+			if d := backoff(attempt, base, max); (d < want/2) || (d > want) {
+				t.Fatalf("Attempt [%d] Backoff [%s] Expected [%s, %s]", attempt, d, want/2, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package deadletter
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-smtp-mailer/broker"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/events"
+)
+
+// Dead Letter (Message that could not be Delivered)
+type Entry struct {
+	ID       string          `json:"id"`                      // Queue Message ID
+	Status   events.Status   `json:"status"`                  // Final Delivery Status
+	Reason   string          `json:"reason"`                  // Why Delivery Failed
+	Attempts int             `json:"attempts"`                // Times Message was Processed (Including Replays)
+	Template string          `json:"template,omitempty"`      // Email Template
+	To       string          `json:"to,omitempty"`            // Email Destination
+	Response string          `json:"smtp-response,omitempty"` // SMTP Server Reply
+	Failed   string          `json:"failed"`                  // Failure TimeStamp
+	Request  json.RawMessage `json:"request"`                 // Original Queue Message
+}
+
+// Dead Letter Queue
+type Queue struct {
+	broker broker.Broker // Queue Server to Park Messages
+	queue  string        // Queue Name
+}
+
+// Open Dead Letter Queue (nil Queue if not Configured)
+func Open(c *config.DeadLetter, b broker.Broker) (*Queue, error) {
+	// Is a Dead Letter Queue Configured?
+	if c == nil { // NO: Rejected Messages are Dropped
+		return nil, nil
+	}
+
+	err := b.QueueDeclare("dead-letter", c.Queue)
+	if err != nil {
+		log.Printf("[deadletter.Open] Failed to Declare Dead Letter Queue [%s]", err)
+		return nil, err
+	}
+
+	return &Queue{
+		broker: b,
+		queue:  c.Queue,
+	}, nil
+}
+
+// Attempts Times Queue Message was Processed (Counting the Current Attempt)
+func Attempts(body []byte) int {
+	msg := messages.QueueMessage{}
+	if msg.UnmarshalJSON(body) != nil {
+		return 1
+	}
+	return msg.RequeueCount() + 1
+}
+
+// NewEntry Create Dead Letter for Delivery with Completed Event
+func NewEntry(d *amqp.Delivery, e *events.Event) *Entry {
+	entry := &Entry{
+		ID:       e.ID,
+		Status:   e.Status,
+		Reason:   e.Error,
+		Attempts: Attempts(d.Body),
+		Template: e.Template,
+		To:       e.To,
+		Response: e.Response,
+		Failed:   e.Completed,
+		Request:  d.Body,
+	}
+
+	// Is the Request JSON?
+	if !json.Valid(d.Body) { // NO: Keep it as a String
+		entry.Request, _ = json.Marshal(string(d.Body))
+	}
+
+	return entry
+}
+
+// Park Publish Dead Letter for Delivery
+func (q *Queue) Park(d *amqp.Delivery, e *events.Event) error {
+	// Do we have a Dead Letter Queue?
+	if q == nil { // NO: Message is Dropped
+		return nil
+	}
+
+	body, err := json.Marshal(NewEntry(d, e))
+	if err != nil {
+		return err
+	}
+
+	err = q.broker.QueuePublish("dead-letter", q.queue, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    e.ID,
+		Type:         string(e.Status),
+		Body:         body,
+	})
+
+	if err != nil {
+		log.Printf("[Park] Failed Parking Message [%s]", e.ID)
+	}
+
+	return err
+}
+
+// Decode Dead Letter from Delivery
+func Decode(d *amqp.Delivery) (*Entry, error) {
+	entry := &Entry{}
+	err := json.Unmarshal(d.Body, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Replay Original Queue Message, Counting the Additional Attempt
+func (e *Entry) Replay() interface{} {
+	msg := &messages.QueueMessage{}
+
+	// Is the Request a Queue Message?
+	if msg.UnmarshalJSON(e.Request) != nil || !msg.IsValid() { // NO: Replay as Is
+		return e.Request
+	}
+
+	// Requeue Count is Carried into the Next Dead Letter
+	for msg.RequeueCount() < e.Attempts {
+		msg.Requeue()
+	}
+	return msg
+}
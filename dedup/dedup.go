@@ -0,0 +1,169 @@
+package dedup
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Bucket for Sent Message Keys
+var bucketSent = []byte("sent")
+
+// Deduplication Store (Keys of Messages Already Sent)
+type Store struct {
+	db  *bolt.DB      // Embedded Database
+	ttl time.Duration // Time to Remember a Key
+}
+
+// Key Deduplication Key for Message (Idempotency Key Wins over Message ID)
+func Key(id string, idempotency string) string {
+	idempotency = strings.TrimSpace(idempotency)
+	if idempotency != "" {
+		return "key:" + idempotency
+	}
+
+	return "id:" + strings.ToLower(id)
+}
+
+// Open Deduplication Store (nil Store if Deduplication is not Configured)
+func Open(c *config.Deduplication) (*Store, error) {
+	// Is Deduplication Enabled?
+	if c == nil { // NO
+		return nil, nil
+	}
+
+	db, err := bolt.Open(c.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Printf("[dedup.Open] Failed to Open Store [%s]", c.Path)
+		return nil, err
+	}
+
+	// Make Sure Bucket Exists
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSent)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Store{
+		db:  db,
+		ttl: time.Duration(c.TTL) * time.Second,
+	}
+
+	// Clear Expired Entries from Previous Runs
+	// NOTE: Failure to Prune is not Fatal (Retried Hourly)
+	count, err := s.Prune()
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Deduplication Store [%s] Pruned [%d] Keys", c.Path, count)
+	}
+
+	return s, nil
+}
+
+// Close Store
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
+
+// Seen Was a Message with this Key Already Sent?
+func (s *Store) Seen(key string) (bool, error) {
+	// Is Deduplication Enabled?
+	if s == nil { // NO: Never Seen
+		return false, nil
+	}
+
+	seen := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketSent).Get([]byte(key))
+
+		// Do we have a Valid Entry?
+		if len(v) != 8 { // NO
+			return nil
+		}
+
+		// Has the Entry Expired?
+		expires := time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+		seen = time.Now().Before(expires)
+		return nil
+	})
+
+	return seen, err
+}
+
+// Record Mark Message with Key as Sent
+func (s *Store) Record(key string) error {
+	// Is Deduplication Enabled?
+	if s == nil { // NO: Nothing to Record
+		return nil
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(time.Now().Add(s.ttl).UnixNano()))
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSent).Put([]byte(key), v)
+	})
+}
+
+// Prune Remove Expired Keys
+func (s *Store) Prune() (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	count := 0
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSent)
+
+		// Collect Expired or Invalid Entries (Deleting while Iterating Skips Keys)
+		var expired [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if (len(v) != 8) || !now.Before(time.Unix(0, int64(binary.BigEndian.Uint64(v)))) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+
+		for _, k := range expired {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		count = len(expired)
+		return nil
+	})
+
+	if err != nil {
+		return 0, errors.New("[dedup.Prune] " + err.Error())
+	}
+
+	return count, nil
+}
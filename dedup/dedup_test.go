@@ -0,0 +1,99 @@
+package dedup
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+func open(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := Open(&config.Deduplication{Path: filepath.Join(t.TempDir(), "dedup.db"), TTL: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestKey(t *testing.T) {
+	if k := Key("MSG-1", ""); k != "id:msg-1" {
+		t.Errorf("Key [%s] Expected [id:msg-1]", k)
+	}
+
+	if k := Key("MSG-1", " order-42 "); k != "key:order-42" {
+		t.Errorf("Key [%s] Expected [key:order-42]", k)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := open(t)
+
+	if seen, err := s.Seen("id:m1"); (err != nil) || seen {
+		t.Fatalf("Unsent Message Seen [%v]", err)
+	}
+
+	if err := s.Record("id:m1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicate Detected
+	if seen, err := s.Seen("id:m1"); (err != nil) || !seen {
+		t.Fatalf("Sent Message not Seen [%v]", err)
+	}
+
+	// Other Messages not Affected
+	if seen, _ := s.Seen("id:m2"); seen {
+		t.Error("Unsent Message Seen")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := open(t)
+
+	s.Record("id:kept")
+	s.ttl = -time.Minute
+	s.Record("id:expired")
+
+	if seen, _ := s.Seen("id:expired"); seen {
+		t.Error("Expired Key Seen")
+	}
+
+	if n, err := s.Prune(); (err != nil) || (n != 1) {
+		t.Fatalf("Pruned [%d] Expected [1] [%v]", n, err)
+	}
+
+	if seen, _ := s.Seen("id:kept"); !seen {
+		t.Error("Unexpired Key Pruned")
+	}
+
+	if n, _ := s.Prune(); n != 0 {
+		t.Errorf("Pruned [%d] Expected [0]", n)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var s *Store
+	if err := s.Record("id:m1"); err != nil {
+		t.Error(err)
+	}
+	if seen, _ := s.Seen("id:m1"); seen {
+		t.Error("Disabled Store Seen Message")
+	}
+	if n, _ := s.Prune(); n != 0 {
+		t.Errorf("Disabled Store Pruned [%d]", n)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-interface/queue"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/deadletter"
+)
+
+// Dead Letter Filters (key=value)
+type dlqFilters map[string]string
+
+func (f dlqFilters) String() string {
+	var l []string
+	for k, v := range f {
+		l = append(l, k+"="+v)
+	}
+	return strings.Join(l, ",")
+}
+
+func (f dlqFilters) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("Invalid Filter [%s] (expected key=value)", s)
+	}
+
+	k := strings.ToLower(strings.TrimSpace(kv[0]))
+	switch k {
+	case "id", "status", "template", "to":
+		f[k] = strings.TrimSpace(kv[1])
+	default:
+		return fmt.Errorf("Unknown Filter [%s]", k)
+	}
+	return nil
+}
+
+// match Does Dead Letter Pass Filters?
+func (f dlqFilters) match(e *deadletter.Entry, since time.Time) bool {
+	for k, v := range f {
+		var field string
+		switch k {
+		case "id":
+			field = e.ID
+		case "status":
+			field = string(e.Status)
+		case "template":
+			field = e.Template
+		case "to":
+			field = e.To
+		}
+
+		if !strings.EqualFold(field, v) {
+			return false
+		}
+	}
+
+	// Filter by Failure Time?
+	if !since.IsZero() { // YES
+		failed, err := time.Parse(time.RFC3339, e.Failed)
+		if (err != nil) || failed.Before(since) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSince Duration Ago (i.e. 24h) or RFC3339 TimeStamp
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid Value for 'since' [%s]", s)
+	}
+	return t, nil
+}
+
+// Retrieved Dead Letter (Unacknowledged until Settled)
+type dlqMessage struct {
+	delivery *amqp.Delivery
+	entry    *deadletter.Entry // nil if Undecodable
+}
+
+// dlqFetch Retrieve all Dead Letters
+//
+// Messages are held until Acknowledged, or Returned to the Queue, so each is
+// seen only once.
+func dlqFetch(mq *queue.AMQPServerConnection, name string) ([]*dlqMessage, error) {
+	var l []*dlqMessage
+	for {
+		d, err := mq.QueueRetrieve("dlq", name)
+		if err != nil {
+			return l, err
+		}
+
+		// Is Queue Empty?
+		if d == nil { // YES
+			return l, nil
+		}
+
+		entry, err := deadletter.Decode(d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Undecodable Dead Letter [%s]\n", err)
+		}
+		l = append(l, &dlqMessage{delivery: d, entry: entry})
+	}
+}
+
+// dlqRelease Return Messages to the Dead Letter Queue
+func dlqRelease(l []*dlqMessage) {
+	for _, m := range l {
+		if err := m.delivery.Nack(false, true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func dlqPrint(e *deadletter.Entry) {
+	id := e.ID
+	if id == "" {
+		id = "-"
+	}
+	fmt.Printf("%s\t%s\t%d\t%s\t%s\t%s\t%s\n", id, e.Failed, e.Attempts, e.Status, e.Template, e.To, e.Reason)
+}
+
+// dlqCommand Inspect and Replay Dead Letters (Returns Exit Code)
+func dlqCommand(args []string) int {
+	filters := dlqFilters{}
+
+	flags := flag.NewFlagSet("dlq", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Inspect and Replay Dead Letters
+
+		Usage:
+		  server dlq [-c /path/to/conf] list [-filter key=value]... [-since t]
+		  server dlq [-c /path/to/conf] show <id>
+		  server dlq [-c /path/to/conf] replay [-filter key=value]... [-since t]
+		  server dlq [-c /path/to/conf] purge [-filter key=value]... [-since t]
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    -filter       Only messages with id, status, template or to equal to value.
+		    -since        Only messages that failed after time (duration ago, i.e. 24h, or RFC3339).
+
+		  Replayed messages are republished to the poll queue, and removed
+		  from the dead letter queue.
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	flags.Var(filters, "filter", "Filter messages (key=value)")
+	sSince := flags.String("since", "", "Only messages failed since")
+	flags.Parse(args)
+
+	// Do we have an Action?
+	if flags.NArg() == 0 { // NO
+		flags.Usage()
+		return 2
+	}
+
+	// Action Options can Follow the Action
+	action := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+
+	since, err := parseSince(*sSince)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	switch action {
+	case "list", "replay", "purge":
+	case "show": // Show by ID
+		if flags.NArg() < 1 {
+			flags.Usage()
+			return 2
+		}
+		filters["id"] = flags.Arg(0)
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Is a Dead Letter Queue Configured?
+	if c.DeadLetter == nil { // NO
+		fmt.Fprintln(os.Stderr, "ERROR: No Dead Letter Queue in Configuration File")
+		return 1
+	}
+
+	// Connect to Queue
+	mq, err := setMQConnection(c.Queue)
+	if err == nil {
+		err = connectAny(mq, c.Queue.Servers)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer mq.CloseConnection()
+
+	_, err = mq.OpenQueueChannel("dlq", c.DeadLetter.Queue, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	all, err := dlqFetch(mq, c.DeadLetter.Queue)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		dlqRelease(all)
+		return 1
+	}
+
+	// Split Selected Messages from the Rest
+	var selected, rest []*dlqMessage
+	for _, m := range all {
+		if (m.entry != nil) && filters.match(m.entry, since) {
+			selected = append(selected, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	defer dlqRelease(rest)
+
+	switch action {
+	case "list":
+		for _, m := range selected {
+			dlqPrint(m.entry)
+		}
+		dlqRelease(selected)
+		fmt.Printf("Dead Letters [%d] Listed [%d]\n", len(all), len(selected))
+	case "show":
+		dlqRelease(selected)
+
+		// Was the Message Found?
+		if len(selected) == 0 { // NO
+			fmt.Fprintf(os.Stderr, "Not Found [%s]\n", filters["id"])
+			return 1
+		}
+
+		for _, m := range selected {
+			b, _ := json.MarshalIndent(m.entry, "", "  ")
+			fmt.Println(string(b))
+		}
+	case "replay":
+		_, err = mq.OpenQueueChannel("dlq", c.Options.PollQueue, true)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			dlqRelease(selected)
+			return 1
+		}
+
+		replayed, failed := 0, 0
+		for _, m := range selected {
+			err = mq.QueuePublishJSON("dlq", c.Options.PollQueue, m.entry.Replay())
+			if err == nil {
+				err = m.delivery.Ack(false)
+			} else {
+				m.delivery.Nack(false, true)
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "FAILED [%s]: %s\n", m.entry.ID, err)
+				failed++
+				continue
+			}
+
+			replayed++
+		}
+		fmt.Printf("Replayed [%d] Failed [%d]\n", replayed, failed)
+
+		if failed > 0 {
+			return 1
+		}
+	case "purge":
+		purged := 0
+		for _, m := range selected {
+			if err := m.delivery.Ack(false); err != nil {
+				fmt.Fprintf(os.Stderr, "FAILED [%s]: %s\n", m.entry.ID, err)
+				continue
+			}
+			purged++
+		}
+		fmt.Printf("Purged [%d]\n", purged)
+	}
+
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/objectvault/queue-interface/queue"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/poller"
+)
+
+// enqueueCommand Publish Requests from JSONL File to Queue (Returns Exit Code)
+func enqueueCommand(args []string) int {
+	flags := flag.NewFlagSet("enqueue", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Publish Email Requests from a JSONL File
+
+		Usage:
+		  server enqueue [-c /path/to/conf] [-rate n] [-dry-run] [requests.jsonl | -]
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    -rate         Maximum messages published per second [default: 0 - no limit].
+		    -dry-run      Validate requests, but don't publish them.
+
+		  Requests are read one per line (from stdin if no file or '-'),
+		  validated locally, and published to the poll queue.
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	fRate := flags.Float64("rate", 0, "Messages per second")
+	bDryRun := flags.Bool("dry-run", false, "Validate only")
+	flags.Parse(args)
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Input
+	var in io.Reader = os.Stdin
+	if (flags.NArg() > 0) && (flags.Arg(0) != "-") {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	// Connect to Queue
+	var mq *queue.AMQPServerConnection
+	if !*bDryRun {
+		mq, err = setMQConnection(c.Queue)
+		if err == nil {
+			err = connectAny(mq, c.Queue.Servers)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer mq.CloseConnection()
+
+		// Make Sure Queue Exists (Messages to Unknown Queues are Dropped)
+		_, err = mq.OpenQueueChannel("enqueue", c.Options.PollQueue, true)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	// Rate Control
+	var throttle <-chan time.Time
+	if *fRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *fRate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	accepted, rejected, failed := 0, 0, 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip Blank Lines
+		if line == "" {
+			continue
+		}
+
+		qm, err := poller.ToQueueMessage(c, []byte(line))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "REJECTED line %d: %s\n", n, err)
+			rejected++
+			continue
+		}
+
+		if *bDryRun {
+			accepted++
+			continue
+		}
+
+		if throttle != nil {
+			<-throttle
+		}
+
+		err = mq.QueuePublishJSON("enqueue", c.Options.PollQueue, qm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED line %d: %s\n", n, err)
+			failed++
+			continue
+		}
+
+		accepted++
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		failed++
+	}
+
+	// Summary
+	verb := "Published"
+	if *bDryRun {
+		verb = "Valid"
+	}
+	fmt.Printf("%s [%d] Rejected [%d] Failed [%d]\n", verb, accepted, rejected, failed)
+
+	if (rejected > 0) || (failed > 0) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package events
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"github.com/objectvault/queue-interface/shared"
+)
+
+// Delivery Status
+type Status string
+
+const (
+	StatusSent       Status = "sent"             // Accepted by SMTP Relay
+	StatusDeferred   Status = "deferred"         // Temporary Failure (Message will be Retried)
+	StatusFailed     Status = "failed"           // Permanent Failure
+	StatusRejected   Status = "rejected-invalid" // Invalid Request (Never Sent)
+	StatusSuppressed Status = "suppressed"       // Recipient in Suppression List (Never Sent)
+	StatusExpired    Status = "expired"          // Expiry Passed before Sending (Never Sent)
+)
+
+// Delivery Status Event
+type Event struct {
+	Version   int    `json:"version"`                 // Event Format Version
+	ID        string `json:"id"`                      // Queue Message ID
+	Status    Status `json:"status"`                  // Delivery Status
+	Template  string `json:"template,omitempty"`      // Email Template
+	To        string `json:"to,omitempty"`            // Email Destination
+	Relay     string `json:"relay,omitempty"`         // SMTP Relay Used (host:port)
+	Response  string `json:"smtp-response,omitempty"` // SMTP Server Reply
+	Error     string `json:"error,omitempty"`         // Error Message
+	Created   string `json:"created,omitempty"`       // Queue Message Creation TimeStamp
+	Received  string `json:"received"`                // Message Received TimeStamp
+	Completed string `json:"completed"`               // Processing Completed TimeStamp
+}
+
+// NewEvent Create Event for Message Received Now
+func NewEvent(id string) *Event {
+	return &Event{
+		Version:  1,
+		ID:       id,
+		Received: shared.UTCTimeStamp(),
+	}
+}
+
+// Complete Set Final Status of Event
+func (e *Event) Complete(s Status, err error) *Event {
+	e.Status = s
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.Completed = shared.UTCTimeStamp()
+	return e
+}
@@ -0,0 +1,111 @@
+package events
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-smtp-mailer/broker"
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Status Event Publisher
+type Publisher struct {
+	broker     broker.Broker // Queue Server to Publish Events
+	exchange   string        // Exchange Name ("" Queue Default Exchange)
+	queue      string        // Queue Name
+	routingKey string        // Routing Key Prefix (Exchange Only)
+}
+
+// NewPublisher Prepare Exchange/Queue to Publish Events (nil Publisher if Events not Configured)
+func NewPublisher(c *config.Events, b broker.Broker) (*Publisher, error) {
+	// Are Events Configured?
+	if c == nil { // NO: Nothing to Publish
+		return nil, nil
+	}
+
+	p := &Publisher{
+		broker:     b,
+		exchange:   c.Exchange,
+		queue:      c.Queue,
+		routingKey: c.RoutingKey,
+	}
+
+	if p.routingKey == "" {
+		p.routingKey = "mailer"
+	}
+
+	// Do we Publish to a Queue?
+	if p.queue != "" { // YES: Make Sure it Exists
+		err := b.QueueDeclare("events", p.queue)
+		if err != nil {
+			log.Printf("[NewPublisher] Failed to Declare Queue [%s] [%s]", p.queue, err)
+			return nil, err
+		}
+	}
+
+	// Do we Publish to an Exchange?
+	if p.exchange != "" { // YES: Make Sure it Exists
+		err := b.ExchangeDeclare("events", p.exchange)
+		if err != nil {
+			log.Printf("[NewPublisher] Failed to Declare Exchange [%s]", p.exchange)
+			return nil, err
+		}
+
+		// Do we also have a Queue?
+		if p.queue != "" { // YES: Bind it to Receive all Events
+			err = b.QueueBind("events", p.queue, p.routingKey+".#", p.exchange)
+			if err != nil {
+				log.Printf("[NewPublisher] Failed to Bind Queue [%s] to Exchange [%s]", p.queue, p.exchange)
+				return nil, err
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Publish Send Event to Exchange/Queue
+func (p *Publisher) Publish(e *Event) error {
+	// Do we have a Publisher?
+	if p == nil { // NO: Events Disabled
+		return nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    e.ID,
+		Type:         string(e.Status),
+		Body:         body,
+	}
+
+	// Exchange Routes by Status, Default Exchange to the Queue
+	if p.exchange != "" {
+		err = p.broker.Publish("events", p.exchange, p.routingKey+"."+string(e.Status), msg)
+	} else {
+		err = p.broker.QueuePublish("events", p.queue, msg)
+	}
+
+	if err != nil {
+		log.Printf("[Publish] Failed Publishing Event for Message [%s]", e.ID)
+	}
+
+	return err
+}
@@ -7,4 +7,15 @@ require (
 	github.com/streadway/amqp v1.0.0
 )
 
-require github.com/objectvault/queue-interface v0.0.1 // indirect
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/objectvault/queue-interface v0.0.1
+	go.etcd.io/bbolt v1.3.9
+	golang.org/x/net v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
@@ -0,0 +1,92 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-interface/queue"
+	"github.com/objectvault/queue-smtp-mailer/api"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/source"
+)
+
+// queueEnqueuer Publish Accepted Messages to the Poll Queue
+//
+// Uses its own connection, so that submissions don't interfere with the
+// connector's reconnect cycle. The connection is (re)opened on demand.
+func queueEnqueuer(c *config.DaemonConfig) api.Enqueuer {
+	var lock sync.Mutex
+	var mq *queue.AMQPServerConnection
+
+	connect := func() error {
+		if mq != nil {
+			mq.CloseConnection()
+			mq = nil
+		}
+
+		q, err := setMQConnection(c.Queue)
+		if err == nil {
+			err = connectAny(q, c.Queue.Servers)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Make Sure Queue Exists (Messages to Unknown Queues are Dropped)
+		_, err = q.OpenQueueChannel("http", c.Options.PollQueue, true)
+		if err != nil {
+			q.CloseConnection()
+			return err
+		}
+
+		mq = q
+		return nil
+	}
+
+	return func(qm *messages.QueueMessage) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		// Connection Open?
+		if mq == nil { // NO
+			if err := connect(); err != nil {
+				return err
+			}
+		}
+
+		err := mq.QueuePublishJSON("http", c.Options.PollQueue, qm)
+
+		// Publish Failed: Connection might be Stale, Retry Once
+		if err != nil {
+			log.Printf("[http] Publish Failed [%s]. Reconnecting", err)
+			if err = connect(); err == nil {
+				err = mq.QueuePublishJSON("http", c.Options.PollQueue, qm)
+			}
+		}
+		return err
+	}
+}
+
+// directoryEnqueuer Drop Accepted Messages into the Source Directory
+func directoryEnqueuer(d *source.Directory) api.Enqueuer {
+	return func(qm *messages.QueueMessage) error {
+		body, err := json.Marshal(qm)
+		if err != nil {
+			return err
+		}
+
+		return d.Submit(qm.ID(), body)
+	}
+}
@@ -0,0 +1,213 @@
+package harness
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-smtp-mailer/broker"
+)
+
+// Settlement of a Delivery
+type Settlement string
+
+const (
+	Unsettled Settlement = ""         // Neither Acknowledged nor Rejected
+	Acked     Settlement = "ack"      // Acknowledged
+	Requeued  Settlement = "requeue"  // Returned to the Queue (Nack/Reject with Requeue)
+	Rejected  Settlement = "rejected" // Removed without Acknowledgement (Nack/Reject)
+)
+
+// Published Message
+type Published struct {
+	Exchange string          // Exchange ("" Queue Default Exchange)
+	Key      string          // Routing Key (Queue Name for Default Exchange)
+	Message  amqp.Publishing // Message
+}
+
+// In-Memory Queue Server
+//
+// Messages published to the default exchange are added to the named queue.
+// Messages published to other exchanges are only recorded. Requeued messages
+// are not redelivered.
+type Queue struct {
+	lock        sync.Mutex
+	tag         uint64                     // Last Delivery Tag
+	queues      map[string][]amqp.Delivery // Messages Waiting by Queue
+	settlements map[uint64]Settlement      // Settlement by Delivery Tag
+	published   []Published                // All Published Messages
+	failing     map[string]error           // Publish Errors by Routing Key
+	closed      chan *amqp.Error           // Close Notification
+}
+
+var _ broker.Broker = (*Queue)(nil)
+
+// NewQueue Empty Queue Server
+func NewQueue() *Queue {
+	return &Queue{
+		queues:      map[string][]amqp.Delivery{},
+		settlements: map[uint64]Settlement{},
+		failing:     map[string]error{},
+		closed:      make(chan *amqp.Error, 1),
+	}
+}
+
+// Push Add Message Body to Queue, Returning its Delivery Tag
+func (q *Queue) Push(queue string, id string, body []byte) uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.push(queue, amqp.Publishing{MessageId: id, ContentType: "application/json", Body: body})
+}
+
+// PushJSON Add Message (Marshalled to JSON) to Queue, Returning its Delivery Tag
+func (q *Queue) PushJSON(queue string, id string, v interface{}) (uint64, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return q.Push(queue, id, b), nil
+}
+
+func (q *Queue) push(queue string, msg amqp.Publishing) uint64 {
+	q.tag++
+	q.queues[queue] = append(q.queues[queue], amqp.Delivery{
+		Acknowledger: q,
+		DeliveryTag:  q.tag,
+		MessageId:    msg.MessageId,
+		ContentType:  msg.ContentType,
+		Type:         msg.Type,
+		Body:         msg.Body,
+	})
+	return q.tag
+}
+
+// Len Messages Waiting in Queue
+func (q *Queue) Len(queue string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.queues[queue])
+}
+
+// Settlement of Delivery with Tag
+func (q *Queue) Settlement(tag uint64) Settlement {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.settlements[tag]
+}
+
+// Published Messages Published to Exchange (or Queue, through the Default Exchange)
+func (q *Queue) Published(exchange string) []Published {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var l []Published
+	for _, p := range q.published {
+		if p.Exchange == exchange {
+			l = append(l, p)
+		}
+	}
+	return l
+}
+
+// FailPublish Simulate Publishing Failure for Routing Key (nil Error Restores Publishing)
+func (q *Queue) FailPublish(key string, err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.failing[key] = err
+}
+
+// Break Simulate Broken Connection
+func (q *Queue) Break() {
+	select {
+	case q.closed <- amqp.ErrClosed:
+	default:
+	}
+}
+
+// BROKER //
+
+func (q *Queue) QueueRetrieve(channel string, queue string) (*amqp.Delivery, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	l := q.queues[queue]
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	d := l[0]
+	q.queues[queue] = l[1:]
+	q.settlements[d.DeliveryTag] = Unsettled
+	return &d, nil
+}
+
+func (q *Queue) QueueDeclare(channel string, queue string) error {
+	return nil
+}
+
+func (q *Queue) QueuePublish(channel string, queue string, msg amqp.Publishing) error {
+	return q.Publish(channel, "", queue, msg)
+}
+
+func (q *Queue) ExchangeDeclare(channel string, exchange string) error {
+	return nil
+}
+
+func (q *Queue) QueueBind(channel string, queue string, key string, exchange string) error {
+	return nil
+}
+
+func (q *Queue) Publish(channel string, exchange string, key string, msg amqp.Publishing) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.failing[key]; err != nil {
+		return err
+	}
+
+	q.published = append(q.published, Published{Exchange: exchange, Key: key, Message: msg})
+	if exchange == "" {
+		q.push(key, msg)
+	}
+	return nil
+}
+
+func (q *Queue) NotifyClose(channel string, queue string) (<-chan *amqp.Error, error) {
+	return q.closed, nil
+}
+
+// ACKNOWLEDGER //
+
+func (q *Queue) Ack(tag uint64, multiple bool) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.settlements[tag] = Acked
+	return nil
+}
+
+func (q *Queue) Nack(tag uint64, multiple bool, requeue bool) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if requeue {
+		q.settlements[tag] = Requeued
+	} else {
+		q.settlements[tag] = Rejected
+	}
+	return nil
+}
+
+func (q *Queue) Reject(tag uint64, requeue bool) error {
+	return q.Nack(tag, false, requeue)
+}
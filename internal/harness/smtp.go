@@ -0,0 +1,213 @@
+package harness
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message Captured by the SMTP Server
+type Message struct {
+	From string   // MAIL FROM Address
+	To   []string // RCPT TO Addresses
+	Data string   // Message (Headers and Body)
+}
+
+// Reply SMTP Server Reply
+type Reply struct {
+	Code int    // Reply Code (i.e. 250, 451, 550)
+	Text string // Reply Text
+}
+
+// In-Process SMTP Server Capturing Messages
+//
+// Replies to MAIL, RCPT and DATA can be changed to simulate temporary (4xx) or
+// permanent (5xx) failures.
+type SMTPServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	replies  map[string]Reply // Reply by Command (MAIL, RCPT, DATA)
+	messages []*Message       // Messages Accepted
+}
+
+// NewSMTPServer Start Server on Random Local Port
+func NewSMTPServer() (*SMTPServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPServer{
+		listener: l,
+		replies:  map[string]Reply{},
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+// Host Server Address
+func (s *SMTPServer) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port Server Port
+func (s *SMTPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close Stop Server
+func (s *SMTPServer) Close() error {
+	return s.listener.Close()
+}
+
+// Respond Set Reply to Command (MAIL, RCPT or DATA)
+func (s *SMTPServer) Respond(command string, code int, text string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.replies[strings.ToUpper(command)] = Reply{Code: code, Text: text}
+}
+
+// Reset Accept Everything Again, and Forget Captured Messages
+func (s *SMTPServer) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.replies = map[string]Reply{}
+	s.messages = nil
+}
+
+// Messages Captured so Far
+func (s *SMTPServer) Messages() []*Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Message{}, s.messages...)
+}
+
+func (s *SMTPServer) reply(command string, code int, text string) Reply {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r, ok := s.replies[command]; ok {
+		return r
+	}
+	return Reply{Code: code, Text: text}
+}
+
+func (s *SMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil { // Listener Closed
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+// session Handle a Single SMTP Session
+func (s *SMTPServer) session(conn net.Conn) {
+	defer conn.Close()
+	t := textproto.NewConn(conn)
+	send := func(r Reply) error {
+		return t.PrintfLine("%d %s", r.Code, r.Text)
+	}
+
+	send(Reply{220, "localhost Test SMTP Server"})
+
+	var msg *Message
+	for {
+		line, err := t.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(line)
+		if i := strings.IndexAny(command, " :"); i > 0 {
+			command = command[:i]
+		}
+
+		switch command {
+		case "EHLO", "HELO":
+			err = send(Reply{250, "localhost"})
+		case "MAIL":
+			r := s.reply("MAIL", 250, "OK")
+			if r.Code < 400 {
+				msg = &Message{From: address(line)}
+			}
+			err = send(r)
+		case "RCPT":
+			// Do we have a Sender?
+			if msg == nil { // NO
+				err = send(Reply{503, "Need MAIL command"})
+				break
+			}
+
+			r := s.reply("RCPT", 250, "OK")
+			if r.Code < 400 {
+				msg.To = append(msg.To, address(line))
+			}
+			err = send(r)
+		case "DATA":
+			// Do we have Recipients?
+			if (msg == nil) || (len(msg.To) == 0) { // NO
+				err = send(Reply{503, "Need RCPT command"})
+				break
+			}
+
+			err = send(Reply{354, "Start mail input"})
+			if err != nil {
+				return
+			}
+
+			var data []byte
+			data, err = t.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			r := s.reply("DATA", 250, "OK")
+			if r.Code < 400 {
+				msg.Data = string(data)
+				s.lock.Lock()
+				s.messages = append(s.messages, msg)
+				s.lock.Unlock()
+			}
+			msg = nil
+			err = send(r)
+		case "RSET":
+			msg = nil
+			err = send(Reply{250, "OK"})
+		case "NOOP":
+			err = send(Reply{250, "OK"})
+		case "QUIT":
+			send(Reply{221, "Bye"})
+			return
+		default:
+			err = send(Reply{502, fmt.Sprintf("Command not Implemented [%s]", command)})
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// address Address from MAIL FROM:<a> or RCPT TO:<a> Line
+func address(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if (start < 0) || (end < start) {
+		return ""
+	}
+	return line[start+1 : end]
+}
@@ -0,0 +1,89 @@
+package mailer
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Functions Available to Text and HTML Templates
+var templateFunctions = map[string]interface{}{
+	"number":  formatNumber,
+	"int":     toInt,
+	"default": defaultValue,
+	"join":    join,
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64: // JSON Numbers
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+
+	return 0, fmt.Errorf("Not a Number [%v]", v)
+}
+
+// formatNumber Format Number with Fixed Decimals (i.e. {{number .total 2}})
+func formatNumber(v interface{}, decimals int) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatFloat(f, 'f', decimals, 64), nil
+}
+
+// toInt Truncate Number to Integer (i.e. {{int .count}})
+func toInt(v interface{}) (int64, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(f), nil
+}
+
+// defaultValue Use Default for Missing or Empty Values (i.e. {{default "Guest" .name}})
+func defaultValue(d interface{}, v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return d
+	case string:
+		if x == "" {
+			return d
+		}
+	}
+
+	return v
+}
+
+// join Join List Values (i.e. {{join .tags ", "}})
+func join(v interface{}, sep string) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Sprint(v)
+	}
+
+	values := make([]string, len(list))
+	for i, e := range list {
+		values[i] = fmt.Sprint(e)
+	}
+
+	return strings.Join(values, sep)
+}
@@ -11,105 +11,261 @@ package mailer
  */
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
 	"net/smtp"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	mailyak "github.com/domodwyer/mailyak/v3"
 
 	"github.com/objectvault/queue-interface/messages"
 	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/schema"
 )
 
-var _connection string
-var _auth smtp.Auth
+// NOTE: Connection Settings are not Cached, so that a Configuration Reload
+// Takes Effect on the Next Message
 
 func getSMTPConnection(c *config.DaemonConfig) string {
-	// Have we already setup a SMTP connection?
-	if _connection == "" { // NO: Setup
-		// Setup Connection Information
-		host := c.SMTPRelay.Server.Host
-		port := c.SMTPRelay.Server.Port
-		if port == 0 {
-			_connection = host + ":" + "25"
-		} else {
-			_connection = host + ":" + strconv.Itoa(port)
-		}
+	// Setup Connection Information
+	host := c.SMTPRelay.Server.Host
+	port := c.SMTPRelay.Server.Port
+	if port == 0 {
+		return host + ":" + "25"
 	}
 
-	return _connection
+	return host + ":" + strconv.Itoa(port)
 }
 
 func getSMTPAuthentication(c *config.DaemonConfig) smtp.Auth {
-	// Have we already setup a SMTP connection?
-	if _auth == nil { // NO: Setup
-		if c.SMTPRelay.Authentication != nil {
-			user := c.SMTPRelay.Authentication.User
-			if user != "" {
-				_auth = smtp.PlainAuth("",
-					user,
-					c.SMTPRelay.Authentication.Password,
-					c.SMTPRelay.Server.Host)
-			}
+	// Do we have Authentication Settings?
+	if c.SMTPRelay.Authentication != nil { // YES
+		user := c.SMTPRelay.Authentication.User
+		if user != "" {
+			return smtp.PlainAuth("",
+				user,
+				c.SMTPRelay.Authentication.Password,
+				c.SMTPRelay.Server.Host)
 		}
 	}
 
-	return _auth
+	return nil
 }
 
-func templatePath(c *config.DaemonConfig, name string, t string) string {
+// Relay SMTP Relay Address (host:port) used to Send Email
+func Relay(c *config.DaemonConfig) string {
+	return getSMTPConnection(c)
+}
+
+// templatePath Template File for Locale (i.e. welcome.pt_br.html.template),
+// Falling Back to Language (welcome.pt.html.template) then Default (welcome.html.template)
+func templatePath(c *config.DaemonConfig, name string, locale string, t string) string {
 	base := c.Paths.Templates
-	path := filepath.Join(base, name+"."+t+".template")
 
-	// Does Template File Exist
+	// Candidate Files in Order of Preference
+	var candidates []string
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale != "" {
+		candidates = append(candidates, name+"."+locale+"."+t+".template")
+		if i := strings.IndexAny(locale, "_-"); i > 0 {
+			candidates = append(candidates, name+"."+locale[:i]+"."+t+".template")
+		}
+	}
+	candidates = append(candidates, name+"."+t+".template")
+
+	for _, candidate := range candidates {
+		path := filepath.Join(base, candidate)
+
+		// Does Template File Exist
+		i, err := os.Stat(path)
+		if (err == nil) && !i.IsDir() { // YES
+			return path
+		}
+	}
+
+	return ""
+}
+
+// ValidateParameters Check Parameters Against Template Schema ({template}.schema.json) if Any
+func ValidateParameters(c *config.DaemonConfig, msg *messages.EmailMessage) error {
+	params := map[string]interface{}{}
+	if p := msg.GetParameters(); p != nil {
+		params = *p
+	}
+
+	return CheckParameters(c, msg.Template(), params)
+}
+
+// CheckParameters Check Parameters Against Named Template Schema if Any
+func CheckParameters(c *config.DaemonConfig, name string, params map[string]interface{}) error {
+	path := filepath.Join(c.Paths.Templates, name+".schema.json")
+
+	// Does Template have a Schema?
 	i, err := os.Stat(path)
-	if os.IsNotExist(err) || i.IsDir() { // NO
-		return ""
+	if os.IsNotExist(err) || ((err == nil) && i.IsDir()) { // NO: Anything Goes
+		return nil
 	}
-	// ELSE: YES
-	return path
+
+	s, err := schema.Load(path)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Request Parameter Names are always lower case
+	s.FoldNames()
+	return s.Validate("params", params)
+}
+
+// TemplateParameters Message Parameters without Null Values (Kept only for Schema Validation)
+func TemplateParameters(msg *messages.EmailMessage) *map[string]interface{} {
+	p := msg.GetParameters()
+	if p == nil {
+		return nil
+	}
+
+	params := make(map[string]interface{}, len(*p))
+	for k, v := range *p {
+		if v != nil {
+			params[k] = v
+		}
+	}
+	return &params
+}
+
+// Sender Address for Messages without 'from'
+const defaultFrom = "noreply@test-to.com"
+
+// Envelope SMTP Envelope (MAIL FROM and RCPT TO Addresses) for Message
+func Envelope(msg *messages.EmailMessage) (string, []string) {
+	return msg.From(defaultFrom), []string{msg.To()}
 }
 
-func SendMail(c *config.DaemonConfig, msg *messages.EmailMessage) error {
+// BuildMail Create Email for Message with Templates Expanded
+func BuildMail(c *config.DaemonConfig, msg *messages.EmailMessage) (*mailyak.MailYak, error) {
 	template := msg.Template()
-	log.Printf("Email Template [%s]", template)
+	log.Printf("Email Template [%s] Locale [%s]", template, msg.Language())
 
 	// Create a new email - specify the SMTP host and auth
 	email := mailyak.New(getSMTPConnection(c), getSMTPAuthentication(c))
 
 	// Initialize Basics
 	email.To(msg.To())
-	email.From(msg.From("noreply@test-to.com"))
+	email.From(msg.From(defaultFrom))
 	email.FromName("Do Not Reply")
 
 	email.Subject("User Activation")
 
-	// Mail Template Files Template
-	textTemplate := templatePath(c, template, "text")
-	htmlTemplate := templatePath(c, template, "html")
+	// Expand Templates
+	rendered, err := Render(c, template, msg.Language(), TemplateParameters(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	if rendered.HasText {
+		email.Plain().Set(rendered.Text)
+	}
+
+	if rendered.HasHTML {
+		email.HTML().Set(rendered.HTML)
+	}
+
+	return email, nil
+}
+
+// RenderMIME Create Email for Message and Render it as MIME (the Bytes Sent)
+func RenderMIME(c *config.DaemonConfig, msg *messages.EmailMessage) ([]byte, error) {
+	email, err := BuildMail(c, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := email.MimeBuf()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SendMIME Send Rendered MIME Message for Message through the SMTP Relay (Returns the Relay's Reply)
+//
+// The message is sent byte for byte as given, so that a copy of what was sent
+// can be kept (re-rendering gives a new Date and MIME boundaries). The session
+// is as net/smtp.SendMail's (STARTTLS and AUTH if offered), but the reply to
+// DATA, which net/smtp discards, is returned.
+func SendMIME(c *config.DaemonConfig, msg *messages.EmailMessage, mime []byte) (string, error) {
+	from, to := Envelope(msg)
+
+	client, err := smtp.Dial(getSMTPConnection(c))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	// Upgrade to TLS if Possible
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: c.SMTPRelay.Server.Host})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if auth := getSMTPAuthentication(c); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return "", errors.New("smtp: server doesn't support AUTH")
+		}
 
-	// Does Template Exist?
-	if (textTemplate == "") && (htmlTemplate == "") { // NO
-		return errors.New("Invalid Template [" + template + "]")
+		err = client.Auth(auth)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	if textTemplate != "" {
-		expandTextTemplate(textTemplate, msg.GetParameters(), email.Plain())
+	err = client.Mail(from)
+	if err != nil {
+		return "", err
 	}
 
-	if htmlTemplate != "" {
-		expandHTMLTemplate(htmlTemplate, msg.GetParameters(), email.HTML())
+	for _, rcpt := range to {
+		err = client.Rcpt(rcpt)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	// Send Email
-	err := email.Send()
+	// DATA (Done by Hand to Keep the Final Reply)
+	id, err := client.Text.Cmd("DATA")
 	if err != nil {
-		log.Print(err)
-		return err
+		return "", err
 	}
 
-	return nil
+	client.Text.StartResponse(id)
+	_, _, err = client.Text.ReadResponse(354)
+	client.Text.EndResponse(id)
+	if err != nil {
+		return "", err
+	}
+
+	w := client.Text.DotWriter()
+	_, err = w.Write(mime)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	code, text, err := client.Text.ReadResponse(250)
+	if err != nil {
+		return "", err
+	}
+
+	// NOTE: Message was Accepted, Failure to Quit is not an Error
+	client.Quit()
+	return fmt.Sprintf("%d %s", code, text), nil
 }
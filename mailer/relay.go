@@ -0,0 +1,55 @@
+package mailer
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// CheckRelay Connect to SMTP Relay and Authenticate (if Configured) without Sending
+func CheckRelay(c *config.DaemonConfig, timeout time.Duration) error {
+	relay := getSMTPConnection(c)
+
+	conn, err := net.DialTimeout("tcp", relay, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, c.SMTPRelay.Server.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Upgrade to TLS, as when Sending
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: c.SMTPRelay.Server.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if auth := getSMTPAuthentication(c); auth != nil {
+		err = client.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.Quit()
+}
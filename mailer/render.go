@@ -0,0 +1,188 @@
+package mailer
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Rendered Template Output
+type Rendered struct {
+	HasText bool   // Template has a Text Part
+	Text    string // Plain Text Part
+	HasHTML bool   // Template has an HTML Part
+	HTML    string // HTML Part
+}
+
+// Render Expand Template Files for Locale with Parameters
+func Render(c *config.DaemonConfig, name string, locale string, params interface{}) (*Rendered, error) {
+	// Mail Template Files Template
+	textTemplate := templatePath(c, name, locale, "text")
+	htmlTemplate := templatePath(c, name, locale, "html")
+
+	// Does Template Exist?
+	if (textTemplate == "") && (htmlTemplate == "") { // NO
+		return nil, fmt.Errorf("%w [%s]", ErrInvalidTemplate, name)
+	}
+
+	r := &Rendered{}
+	if textTemplate != "" {
+		var b bytes.Buffer
+		err := expandTextTemplate(textTemplate, params, &b)
+		if err != nil {
+			return nil, fmt.Errorf("%w [%s]", ErrTemplateRender, err)
+		}
+		r.HasText, r.Text = true, b.String()
+	}
+
+	if htmlTemplate != "" {
+		var b bytes.Buffer
+		err := expandHTMLTemplate(htmlTemplate, params, &b)
+		if err != nil {
+			return nil, fmt.Errorf("%w [%s]", ErrTemplateRender, err)
+		}
+		r.HasHTML, r.HTML = true, b.String()
+	}
+
+	return r, nil
+}
+
+// ParseTemplate Check Syntax of Template Files for Locale ("" Base Template)
+func ParseTemplate(c *config.DaemonConfig, name string, locale string) error {
+	textTemplate := templatePath(c, name, locale, "text")
+	htmlTemplate := templatePath(c, name, locale, "html")
+
+	if (textTemplate == "") && (htmlTemplate == "") {
+		return fmt.Errorf("%w [%s]", ErrInvalidTemplate, name)
+	}
+
+	if textTemplate != "" {
+		if _, err := parseTextTemplate(textTemplate); err != nil {
+			return err
+		}
+	}
+
+	if htmlTemplate != "" {
+		if _, err := parseHTMLTemplate(htmlTemplate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Locale Segment of Template File Name (i.e. pt, pt_br or pt-BR)
+var localeSegment = regexp.MustCompile(`^[a-z]{2,3}([_-][a-z0-9]{2,8})?$`)
+
+// splitLocale Split Template File Name (Suffix Removed) into Template Name and Locale
+func splitLocale(name string) (string, string) {
+	i := strings.LastIndex(name, ".")
+	if (i > 0) && localeSegment.MatchString(strings.ToLower(name[i+1:])) {
+		return name[:i], name[i+1:]
+	}
+
+	return name, ""
+}
+
+// templateFiles Templates in Template Directory with their Locale Variants
+func templateFiles(c *config.DaemonConfig) (map[string]map[string]bool, error) {
+	files, err := os.ReadDir(c.Paths.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]map[string]bool{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		for _, suffix := range []string{".text.template", ".html.template"} {
+			if !strings.HasSuffix(f.Name(), suffix) {
+				continue
+			}
+
+			name, locale := splitLocale(strings.TrimSuffix(f.Name(), suffix))
+			if templates[name] == nil {
+				templates[name] = map[string]bool{}
+			}
+			if locale != "" {
+				templates[name][locale] = true
+			}
+		}
+	}
+
+	return templates, nil
+}
+
+// Templates Names of Templates in Template Directory (Sorted, Locale Variants Excluded)
+func Templates(c *config.DaemonConfig) ([]string, error) {
+	templates, err := templateFiles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(templates))
+	for n := range templates {
+		list = append(list, n)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Locales Locales with Template Files of their Own for Template (Sorted)
+func Locales(c *config.DaemonConfig, name string) ([]string, error) {
+	templates, err := templateFiles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(templates[name]))
+	for l := range templates[name] {
+		list = append(list, l)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// SampleParameters Sample Parameters for Template ({template}.sample.json), nil if None
+func SampleParameters(c *config.DaemonConfig, name string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(filepath.Join(c.Paths.Templates, name+".sample.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sample := map[string]interface{}{}
+	err = json.Unmarshal(b, &sample)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Sample Parameters [%s]", err)
+	}
+
+	// NOTE: Request Parameter Names are always lower case
+	params := make(map[string]interface{}, len(sample))
+	for k, v := range sample {
+		params[strings.ToLower(k)] = v
+	}
+
+	return params, nil
+}
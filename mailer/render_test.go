@@ -0,0 +1,107 @@
+package mailer
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// templates Configuration with Template Files
+func templates(t *testing.T, files map[string]string) *config.DaemonConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &config.DaemonConfig{Paths: &config.Paths{Templates: dir}}
+}
+
+func TestTemplates(t *testing.T) {
+	c := templates(t, map[string]string{
+		"welcome.text.template":       "Hello {{.name}}",
+		"welcome.html.template":       "<p>{{.name}}</p>",
+		"welcome.pt.text.template":    "Olá {{.name}}",
+		"welcome.pt_br.html.template": "<p>Olá {{.name}}</p>",
+		"order.v2.text.template":      "Order {{.id}}",
+		"welcome.sample.json":         `{"name": "Ana"}`,
+	})
+
+	names, err := Templates(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"order.v2", "welcome"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Templates %v Expected %v", names, want)
+	}
+
+	locales, err := Locales(c, "welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pt", "pt_br"}; !reflect.DeepEqual(locales, want) {
+		t.Errorf("Locales %v Expected %v", locales, want)
+	}
+
+	if locales, _ := Locales(c, "order.v2"); len(locales) != 0 {
+		t.Errorf("Unexpected Locales %v", locales)
+	}
+}
+
+func TestRenderLocale(t *testing.T) {
+	c := templates(t, map[string]string{
+		"welcome.text.template":       "Hello {{.name}}",
+		"welcome.pt.text.template":    "Olá {{.name}}",
+		"welcome.pt_br.html.template": "<p>Olá {{.name}}</p>",
+		"broken.text.template":        "Hello",
+		"broken.pt.text.template":     "Olá {{.name",
+	})
+	params := map[string]interface{}{"name": "Ana"}
+
+	tests := []struct {
+		locale string
+		text   string
+		html   string
+	}{
+		{"", "Hello Ana", ""},
+		{"fr", "Hello Ana", ""},
+		{"pt", "Olá Ana", ""},
+		{"pt_BR", "Olá Ana", "<p>Olá Ana</p>"},
+	}
+
+	for _, tt := range tests {
+		r, err := Render(c, "welcome", tt.locale, params)
+		if err != nil {
+			t.Fatalf("Locale [%s] Failed [%s]", tt.locale, err)
+		}
+
+		if (r.Text != tt.text) || (r.HasHTML != (tt.html != "")) || (r.HTML != tt.html) {
+			t.Errorf("Locale [%s] Rendered %+v", tt.locale, r)
+		}
+	}
+
+	// Syntax Checked per Locale
+	if err := ParseTemplate(c, "broken", ""); err != nil {
+		t.Errorf("Base Template Failed [%s]", err)
+	}
+	if err := ParseTemplate(c, "broken", "pt"); (err == nil) || !strings.Contains(err.Error(), "broken.pt") {
+		t.Errorf("Error [%v] Expected Locale Variant Syntax Error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package mailer
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// Error for Requests with no Matching Template Files
+var ErrInvalidTemplate = errors.New("Invalid Template")
+
+// Error for Templates that Fail with the Request Parameters
+var ErrTemplateRender = errors.New("Template Render Failed")
+
+// SMTPResponse Extract SMTP Server Reply from Send Error (0 if not an SMTP Reply)
+func SMTPResponse(err error) (int, string) {
+	var r *textproto.Error
+	if errors.As(err, &r) {
+		return r.Code, r.Msg
+	}
+
+	return 0, ""
+}
+
+// Response Formatted SMTP Reply in Send Error ("" if not an SMTP Reply)
+func Response(err error) string {
+	// Is it an SMTP Reply?
+	code, msg := SMTPResponse(err)
+	if code == 0 { // NO: Connection or Local Error
+		return ""
+	}
+
+	return fmt.Sprintf("%d %s", code, msg)
+}
+
+// IsPermanent Will Retrying the Message Fail Again?
+func IsPermanent(err error) bool {
+	// Missing Templates will not Appear on their Own, Nor will Parameters Change
+	if errors.Is(err, ErrInvalidTemplate) || errors.Is(err, ErrTemplateRender) {
+		return true
+	}
+
+	// SMTP 5xx Replies are Permanent Failures
+	code, _ := SMTPResponse(err)
+	return (code >= 500) && (code < 600)
+}
+
+// IsBadRecipient Is Error a Permanent Rejection of the Recipient Address?
+func IsBadRecipient(err error) bool {
+	code, msg := SMTPResponse(err)
+
+	// Does Reply have an Enhanced Status Code (RFC 3463)?
+	if strings.HasPrefix(msg, "5.") { // YES: Bad Address or Disabled Mailbox
+		return strings.HasPrefix(msg, "5.1.") || strings.HasPrefix(msg, "5.2.1")
+	}
+
+	// Mailbox Unavailable, User not Local, Mailbox Name not Allowed
+	return (code == 550) || (code == 551) || (code == 553)
+}
@@ -13,12 +13,17 @@ package mailer
 import (
 	"io"
 	"log"
+	"path/filepath"
 
 	"text/template"
 )
 
+func parseTextTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(templateFunctions).ParseFiles(path)
+}
+
 func expandTextTemplate(path string, params interface{}, w io.Writer) error {
-	t, err := template.ParseFiles(path)
+	t, err := parseTextTemplate(path)
 	if err != nil {
 		log.Print(err)
 		return err
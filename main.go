@@ -11,6 +11,7 @@ package main
  */
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -18,17 +19,27 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/objectvault/queue-interface/queue"
 	"github.com/objectvault/queue-interface/shared"
+	"github.com/objectvault/queue-smtp-mailer/address"
+	"github.com/objectvault/queue-smtp-mailer/api"
+	"github.com/objectvault/queue-smtp-mailer/archive"
+	"github.com/objectvault/queue-smtp-mailer/broker"
 	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/deadletter"
+	"github.com/objectvault/queue-smtp-mailer/dedup"
+	"github.com/objectvault/queue-smtp-mailer/events"
 	"github.com/objectvault/queue-smtp-mailer/poller"
+	"github.com/objectvault/queue-smtp-mailer/ratelimit"
+	"github.com/objectvault/queue-smtp-mailer/source"
+	"github.com/objectvault/queue-smtp-mailer/spool"
+	"github.com/objectvault/queue-smtp-mailer/status"
+	"github.com/objectvault/queue-smtp-mailer/supervisor"
+	"github.com/objectvault/queue-smtp-mailer/suppression"
 )
 
-// Channels to Control Daemon
-var signals chan os.Signal
-var done chan bool
-
 // Queue Connection
 var mailerMQ *queue.AMQPServerConnection
 
@@ -44,15 +55,78 @@ func setMQConnection(c *shared.Queue) (*queue.AMQPServerConnection, error) {
 	return q, nil
 }
 
+// reload Re-Read Configuration File, Keeping Current Configuration if Invalid
+func reload(path string, s *poller.Services) {
+	log.Printf("Reloading Configuration File [%s]", path)
+
+	c, err := config.Load(path)
+	if err != nil {
+		log.Printf("Reload Failed [%s]. Keeping Current Configuration", err)
+		return
+	}
+
+	// Would Delivery Windows be Ignored? (The Scheduler is only Opened on Start)
+	if (len(c.Windows) > 0) && (s.Scheduler == nil) { // YES
+		log.Print("Reload Failed [Delivery Windows require a Scheduler, Restart to Enable it]. Keeping Current Configuration")
+		return
+	}
+
+	// Log What Changed
+	changes := config.Diff(config.Config(), c)
+	if len(changes) == 0 {
+		log.Print("Reload: No Changes")
+		return
+	}
+
+	for _, change := range changes {
+		log.Printf("Reload: %s", change)
+	}
+
+	config.Set(c)
+
+	// Apply New Rate Limits
+	s.Limiter.Update(c)
+}
+
 // MAIN //
 func main() {
+	// SUBCOMMANDS //
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "suppress":
+			os.Exit(suppressCommand(os.Args[2:]))
+		case "validate":
+			os.Exit(validateCommand(os.Args[2:]))
+		case "render":
+			os.Exit(renderCommand(os.Args[2:]))
+		case "send":
+			os.Exit(sendCommand(os.Args[2:]))
+		case "enqueue":
+			os.Exit(enqueueCommand(os.Args[2:]))
+		case "dlq":
+			os.Exit(dlqCommand(os.Args[2:]))
+		case "status":
+			os.Exit(statusCommand(os.Args[2:]))
+		case "archive":
+			os.Exit(archiveCommand(os.Args[2:]))
+		}
+	}
+
 	// COMMAND LINE PARSER //
 	flag.Usage = func() {
 		usage := `
 		AMQP Queue Mailer
 
 		Usage:
-		  server -c /path/to/conf
+		  server -c /path/to/conf [-p]
+		  server suppress [-c /path/to/conf] list | add <address> [reason] | remove <address>
+		  server validate [-c /path/to/conf] [-relay]
+		  server render [-c /path/to/conf] -t <template> [-l <locale>] [-p params.json] | -r request.json
+		  server send [-c /path/to/conf] --request request.json [--dry-run]
+		  server enqueue [-c /path/to/conf] [-rate n] [-dry-run] [requests.jsonl | -]
+		  server dlq [-c /path/to/conf] list | show <id> | replay | purge [-filter key=value]... [-since t]
+		  server status [-c /path/to/conf] <id> | [-to address] [-template name] [-stage s] [-since t] [-limit n]
+		  server archive [-c /path/to/conf] get <id> [-o file] | list <address>
 		  server -v | --version
 		  server -h | --help
 
@@ -60,12 +134,37 @@ func main() {
 		    -h --help     Show this screen.
 		    -v            Show version.
 		    -c            Path to configuration file [default: ./mailer.json].
+		    -p            Print effective configuration (secrets redacted) and exit.
+
+		HTTP API:
+		  With 'http' configured, requests (same JSON as queue messages) are
+		  accepted by POST /v1/messages with 'Authorization: Bearer <token>'.
+		  Responds 202 {"id": ...} once the request is queued.
+		  With 'status' configured, GET /v1/messages/<id> returns a message's
+		  lifecycle, and GET /v1/messages?to=&template=&stage=&since=&limit=
+		  searches them. GET /debug/vars returns counters (i.e. rate limit hits).
+
+		Environment:
+		  Any setting can be overridden by MAILER_<PATH>, where PATH is the
+		  setting's path in the configuration file, upper cased, with '.' and
+		  '-' replaced by '_' (list elements by index), i.e.:
+
+		    MAILER_RELAY_SERVER_HOST            relay.server.host
+		    MAILER_OPTIONS_POLL_INTERVAL        options.poll-interval
+		    MAILER_QUEUE_SERVERS_0_PASSWORD     queue.servers[0].password
+
+		  Append _FILE to read the value from a file (Docker/Kubernetes
+		  secrets), i.e. MAILER_RELAY_AUTHENTICATION_PASSWORD_FILE.
+
+		  Precedence: MAILER_<PATH>, then MAILER_<PATH>_FILE, then the
+		  configuration file. Map settings can only be set in the file.
 		`
 
 		fmt.Println(usage)
 	}
 	sConfPath := flag.String("c", "./mailer.json", "Path to configuration file")
 	bVersion := flag.Bool("v", false, "Path to configuration file")
+	bPrint := flag.Bool("p", false, "Print effective configuration")
 	flag.Parse()
 
 	// Version Flag Set?
@@ -74,6 +173,22 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Print Configuration Flag Set?
+	if *bPrint { // YES: Display Effective Configuration and Exit
+		c, err := config.Load(*sConfPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := config.Redacted(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(b))
+		os.Exit(0)
+	}
+
 	// LOG
 	log.Print("Starting Daemon")
 
@@ -84,9 +199,54 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Make Configuration Current (Replaced on Reload)
+	config.Set(c)
+
 	// Set Message Queue Connection Settings
 	mailerMQ, _ = setMQConnection(c.Queue)
 
+	// Message Processing Services
+	services := &poller.Services{
+		Limiter:   ratelimit.NewLimiter(c),
+		Validator: address.NewValidator(c.Validation),
+	}
+
+	// Open Deduplication Store
+	services.Dedup, err = dedup.Open(c.Dedup)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open Suppression List
+	services.Suppression, err = suppression.Open(c.Suppression)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open Outbox Spool
+	services.Spool, err = spool.Open(c.Spool)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open Scheduler (Messages Held until 'send_at')
+	services.Scheduler, err = spool.Open(c.Scheduler)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open Status Store
+	services.Status, err = status.Open(c.Status)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open Archive
+	services.Archive, err = archive.Open(c.Archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// After everything is Done Make Sure to Close Everything
 	defer func() {
 		log.Print("EXITING: Close All Connections")
@@ -95,16 +255,20 @@ func main() {
 		if mailerMQ != nil { // YES: Close it
 			mailerMQ.CloseConnection()
 		}
-	}()
 
-	// Clear Shutdown Flag
-	poller.Shutdown = false
+		// Close Deduplication Store
+		services.Dedup.Close()
 
-	// Create Channels
-	signals = make(chan os.Signal, 1)
-	done = make(chan bool, 1)
+		// Close Status Store
+		services.Status.Close()
+	}()
+
+	// Daemon Lifetime
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Capture Termination Signals
+	signals := make(chan os.Signal, 2)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 
 	// Create Signal Handler
@@ -116,17 +280,132 @@ func main() {
 		fmt.Println()
 		fmt.Println(s)
 
-		// Stop Daemon
-		log.Print("Starting Shutdown Process")
-
 		// Stop Other Threads
-		poller.Shutdown = true
+		cancel()
+
+		// Second Signal: Don't Wait for In-Flight Messages
+		s = <-signals
+		log.Printf("Received [%s] during Shutdown. Exiting Now", s)
+		os.Exit(1)
 	}()
 
-	// Start Connection Thread
-	go connector(c)
+	// Reload Configuration on SIGHUP
+	daemon := supervisor.New("daemon")
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	daemon.Go("reload", func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reloads:
+				reload(*sConfPath, services)
+			}
+		}
+	})
+
+	// Do we Keep Records with a Retention Period?
+	if (services.Dedup != nil) || (services.Status != nil) || (services.Archive != nil) { // YES: Remove Expired Records Periodically
+		daemon.Go("prune", func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					services.Prune()
+				}
+			}
+		})
+	}
+
+	// Do we Send from a Spool?
+	if (services.Spool != nil) || (services.Scheduler != nil) { // YES: Start Senders (Independent of the Poll Source)
+		// Do we Publish Events and Dead Letters?
+		var b broker.Broker
+		if c.Source.Type != config.SourceDirectory { // YES: Over a Connection of their Own
+			senders := newDialBroker(c)
+			defer senders.Close()
+			b = senders
+		}
+
+		publisher, err := events.NewPublisher(c.Events, b)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		dead, err := deadletter.Open(c.DeadLetter, b)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Send Spooled Messages (Including those Recovered from Previous Runs)
+		if services.Spool != nil {
+			daemon.Go("spool", func() { poller.Drain(ctx, c, services, services.Spool, publisher, dead) })
+		}
+
+		// Send Scheduled Messages when Due
+		if services.Scheduler != nil {
+			daemon.Go("scheduler", func() { poller.Drain(ctx, c, services, services.Scheduler, publisher, dead) })
+		}
+	}
+
+	// Where do HTTP Submissions Go?
+	var enqueue api.Enqueuer
+
+	// Do we Read Requests from a Directory?
+	if c.Source.Type == config.SourceDirectory { // YES: No Queue Connection Required
+		src, err := source.OpenDirectory(c.Source.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		enqueue = directoryEnqueuer(src)
+
+		daemon.Go("poller", func() {
+			poller.Poller(ctx, c, src, nil, services)
+
+			// Poller Stopped: Stop Daemon
+			cancel()
+		})
+	} else { // NO: Start Connection Thread
+		enqueue = queueEnqueuer(c)
+
+		daemon.Go("connector", func() {
+			err := connector(ctx, c, mailerMQ, services)
+			if err != nil {
+				log.Print(err)
+			}
+
+			// Connector Stopped: Stop Daemon
+			cancel()
+		})
+	}
+
+	// Is the HTTP API Enabled?
+	if c.HTTP != nil { // YES: Start Listener
+		server := api.New(c, enqueue, services.Status)
+		daemon.Go("http", func() {
+			err := server.Run(ctx)
+			if err != nil {
+				log.Print(err)
+
+				// Listener Failed: Stop Daemon
+				cancel()
+			}
+		})
+	}
+
+	// Wait for Shutdown
+	<-ctx.Done()
+	log.Print("Starting Shutdown Process")
+
+	// Give In-Flight Messages Time to Finish
+	grace := time.Duration(config.Config().Options.ShutdownGrace) * time.Second
+	if !daemon.Wait(grace) {
+		log.Print("Shutdown Grace Period Expired. Unacknowledged Messages will be Redelivered")
+	}
 
-	// Capture Done Signal
-	<-done
 	log.Print("Exiting Daemon")
 }
@@ -11,45 +11,150 @@ package poller
  */
 
 import (
+	"context"
 	"log"
 	"time"
 
-	"github.com/objectvault/queue-interface/queue"
+	"github.com/objectvault/queue-smtp-mailer/address"
+	"github.com/objectvault/queue-smtp-mailer/archive"
+	"github.com/objectvault/queue-smtp-mailer/broker"
 	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/deadletter"
+	"github.com/objectvault/queue-smtp-mailer/dedup"
+	"github.com/objectvault/queue-smtp-mailer/events"
+	"github.com/objectvault/queue-smtp-mailer/ratelimit"
+	"github.com/objectvault/queue-smtp-mailer/source"
+	"github.com/objectvault/queue-smtp-mailer/spool"
+	"github.com/objectvault/queue-smtp-mailer/status"
+	"github.com/objectvault/queue-smtp-mailer/supervisor"
+	"github.com/objectvault/queue-smtp-mailer/suppression"
 )
 
-// Flags
-var Shutdown bool // Shutdown Poller
+// Message Processing Services (nil Services are Disabled)
+type Services struct {
+	Archive     *archive.Archive   // Encrypted Copies of Sent Messages
+	Dedup       *dedup.Store       // Duplicate Delivery Protection
+	Limiter     *ratelimit.Limiter // Outbound Send Rate Limits
+	Scheduler   *spool.Spool       // Messages Held until Due ('send_at' or Delivery Window)
+	Spool       *spool.Spool       // Local Outbox (Messages Sent from Spool)
+	Status      *status.Store      // Message Lifecycle Records
+	Suppression *suppression.List  // Addresses not to Send to
+	Validator   *address.Validator // Recipient Domain MX Checks
+}
+
+// Prune Remove Expired Records from Stores with a Retention Period (Returns Number Removed)
+func (s *Services) Prune() int {
+	total := 0
+
+	count, err := s.Dedup.Prune()
+	if err != nil {
+		log.Print(err)
+	} else if count > 0 {
+		log.Printf("Deduplication Store Pruned [%d] Keys", count)
+	}
+	total += count
 
-func Poller(c *config.DaemonConfig, mailerMQ *queue.AMQPServerConnection) {
+	count, err = s.Status.Prune()
+	if err != nil {
+		log.Print(err)
+	} else if count > 0 {
+		log.Printf("Status Store Pruned [%d] Records", count)
+	}
+	total += count
+
+	count, err = s.Archive.Prune()
+	if err != nil {
+		log.Print(err)
+	} else if count > 0 {
+		log.Printf("Archive Pruned [%d] Messages", count)
+	}
+	return total + count
+}
+
+// Poller Read Messages until Context is Cancelled or Connection Breaks
+//
+// Messages are processed in supervised goroutines, which the Poller waits for
+// (up to the shutdown grace period) before returning, so that they can still be
+// acknowledged on the connection.
+//
+// With a Spool, messages are acknowledged as soon as they are spooled, and sent
+// from the spool by Drain. Scheduled messages are sent from the scheduler the
+// same way.
+//
+// The Broker is used to publish status events and dead letters, and can be nil
+// if neither is configured.
+func Poller(ctx context.Context, c *config.DaemonConfig, src source.Source, b broker.Broker, s *Services) {
 	// Number of Sequential Errors
 	errorCount := 0
 
+	// Message Processing Goroutines
+	workers := supervisor.New("poller")
+	defer func() {
+		workers.Wait(time.Duration(c.Options.ShutdownGrace) * time.Second)
+	}()
+
+	// Stop Workers when the Poller Stops (Source Closed)
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
 	// Poller Defaults
 	maxMessages := c.Options.PollMaxMessages
 	interval := time.Duration(c.Options.PollInterval*1000) * time.Millisecond
-	name := "inbox"
 
 	log.Print("START: Message Poller")
 	log.Printf("Max of Messages [%d] per POLL", maxMessages)
 	log.Printf("POLL Interval [%d]s", c.Options.PollInterval)
-	log.Printf("POLL Queue [%s]", name)
+	log.Printf("POLL Source [%s]", src.Name())
+
+	// Status Events Publisher
+	publisher, err := events.NewPublisher(c.Events, b)
+	if err != nil { // Presume Bad Connection
+		log.Print("STOP: Message Poller")
+		return
+	}
+
+	// Dead Letter Queue for Rejected and Failed Messages
+	dead, err := deadletter.Open(c.DeadLetter, b)
+	if err != nil { // Presume Bad Connection
+		log.Print("STOP: Message Poller")
+		return
+	}
+
+	// Get Notified as soon as the Source Breaks
+	closed := src.Closed()
 
 	// ENDLESS Loop
 	for {
 		// Do we Want to Stop the Poller?
-		if Shutdown { // YES: Break Out of Loop
+		if ctx.Err() != nil { // YES: Break Out of Loop
 			log.Print("Stopping Message Poller...")
 			break
 		}
 
+		// Pick Up Reloaded Configuration
+		if current := config.Config(); (current != nil) && (current != c) {
+			c = current
+			maxMessages = c.Options.PollMaxMessages
+			interval = time.Duration(c.Options.PollInterval*1000) * time.Millisecond
+			log.Printf("Configuration Reloaded: Max of Messages [%d] per POLL, Interval [%d]s", maxMessages, c.Options.PollInterval)
+		}
+
 		log.Print("Retrieving Messages...")
 		for i := 0; i < maxMessages; i++ {
-			delivery, err := mailerMQ.QueueRetrieve("read", name)
+			delivery, err := src.Fetch()
 
 			if err != nil {
 				log.Printf("Error [%d] Reading Message...", errorCount)
 
+				// Did the Source Break?
+				select {
+				case e := <-closed: // YES: No Point Retrying
+					log.Printf("Source Closed [%v]. Stopping Poller...", e)
+					log.Print("STOP: Message Poller")
+					return
+				default:
+				}
+
 				errorCount++
 				if errorCount > 10 {
 					log.Print("Too Many Error. Stopping Poller...")
@@ -69,12 +174,25 @@ func Poller(c *config.DaemonConfig, mailerMQ *queue.AMQPServerConnection) {
 				break
 			}
 
-			// Start Mailer Thread
-			go process(c, delivery)
+			// Can we Hand the Message to the Spool?
+			if (s.Spool != nil) && spoolDelivery(s.Spool, delivery) { // YES
+				continue
+			}
+
+			// Start Mailer Thread (with Configuration Current at Delivery)
+			current := c
+			workers.Go("process", func() { process(ctx, current, s, publisher, dead, delivery) })
 		}
 
 		log.Print("Sleeping...")
-		time.Sleep(interval)
+		select {
+		case <-ctx.Done():
+		case e := <-closed:
+			log.Printf("Source Closed [%v]. Stopping Poller...", e)
+			log.Print("STOP: Message Poller")
+			return
+		case <-time.After(interval):
+		}
 	}
 
 	log.Print("STOP: Message Poller")
@@ -0,0 +1,299 @@
+package poller
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/dedup"
+	"github.com/objectvault/queue-smtp-mailer/events"
+	"github.com/objectvault/queue-smtp-mailer/internal/harness"
+	"github.com/objectvault/queue-smtp-mailer/source"
+	"github.com/objectvault/queue-smtp-mailer/spool"
+)
+
+// waitFor Poll Condition until True or Timeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout Waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// start Run Poller until Test Ends (Returns Channel Closed when Poller Stops)
+func (f *fixture) start(t *testing.T) (context.CancelFunc, <-chan struct{}) {
+	src, err := source.NewAMQP(f.queue, f.config.Options.PollQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Poller(ctx, f.config, src, f.queue, f.services)
+		close(stopped)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return cancel, stopped
+}
+
+// drain Send from Spool until Test Ends
+func (f *fixture) drain(t *testing.T, sp *spool.Spool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Drain(ctx, f.config, f.services, sp, f.publisher, f.dead)
+		close(stopped)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func TestPoller(t *testing.T) {
+	f := newFixture(t)
+
+	good := f.queue.Push("inbox", "m1", request(t, "m1", map[string]interface{}{"template": "welcome", "to": "a@example.com", "params": map[string]interface{}{"name": "Ana"}}))
+	bad := f.queue.Push("inbox", "m2", []byte("{}"))
+
+	cancel, stopped := f.start(t)
+	waitFor(t, "Messages Settled", func() bool {
+		return (f.queue.Settlement(good) == harness.Acked) && (f.queue.Settlement(bad) == harness.Rejected)
+	})
+
+	if n := len(f.smtp.Messages()); n != 1 {
+		t.Errorf("Messages Sent [%d] Expected [1]", n)
+	}
+
+	// Stops Immediately on Cancel (without Waiting for the Poll Interval)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Poller did not Stop on Cancel")
+	}
+}
+
+func TestPollerBrokenConnection(t *testing.T) {
+	f := newFixture(t)
+	_, stopped := f.start(t)
+
+	f.queue.Break()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Poller did not Stop on Broken Connection")
+	}
+}
+
+func TestPollerSpool(t *testing.T) {
+	f := newFixture(t)
+	f.config.Spool = &config.Spool{Path: t.TempDir(), RetryInterval: 1, RetryMaxInterval: 1}
+
+	var err error
+	f.services.Spool, err = spool.Open(f.config.Spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relay Down: Message Acknowledged once Spooled, and Retried
+	f.smtp.Respond("MAIL", 421, "Service not available")
+	tag := f.queue.Push("inbox", "m1", request(t, "m1", map[string]interface{}{"template": "welcome", "to": "a@example.com", "params": map[string]interface{}{"name": "Ana"}}))
+
+	f.start(t)
+	f.drain(t, f.services.Spool)
+	waitFor(t, "Message Spooled", func() bool { return f.queue.Settlement(tag) == harness.Acked })
+	waitFor(t, "Send Attempt", func() bool { return len(f.events(t)) > 0 })
+
+	// Relay Back: Message Sent from Spool
+	f.smtp.Reset()
+	waitFor(t, "Message Sent", func() bool { return len(f.smtp.Messages()) == 1 })
+	waitFor(t, "Spool Empty", func() bool {
+		items, next, _ := f.services.Spool.Due(time.Now().Add(time.Hour))
+		return (len(items) == 0) && next.IsZero()
+	})
+}
+
+func TestDrainWithoutPoller(t *testing.T) {
+	f := newFixture(t)
+	f.config.Spool = &config.Spool{Path: t.TempDir(), RetryInterval: 1, RetryMaxInterval: 1}
+
+	var err error
+	f.services.Spool, err = spool.Open(f.config.Spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Message Left in Spool by a Previous Run (Poll Queue Unreachable)
+	f.queue.Push("spooled", "m1", request(t, "m1", map[string]interface{}{"template": "welcome", "to": "a@example.com", "params": map[string]interface{}{"name": "Ana"}}))
+	d, _ := f.queue.QueueRetrieve("read", "spooled")
+	if err = f.services.Spool.Put(d); err != nil {
+		t.Fatal(err)
+	}
+
+	f.drain(t, f.services.Spool)
+	waitFor(t, "Message Sent", func() bool { return len(f.smtp.Messages()) == 1 })
+	waitFor(t, "Status Event", func() bool { return len(f.events(t)) == 1 })
+	f.status(t, events.StatusSent)
+}
+
+func TestPollerScheduled(t *testing.T) {
+	f := newFixture(t)
+	f.config.Scheduler = &config.Spool{Path: t.TempDir(), RetryInterval: 1, RetryMaxInterval: 1}
+
+	var err error
+	f.services.Scheduler, err = spool.Open(f.config.Scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Now().Add(2 * time.Second).Truncate(time.Second)
+	tag := f.queue.Push("inbox", "m1", request(t, "m1", map[string]interface{}{
+		"template": "welcome",
+		"to":       "a@example.com",
+		"params":   map[string]interface{}{"name": "Ana"},
+		"send_at":  due.Format(time.RFC3339),
+	}))
+
+	f.start(t)
+	f.drain(t, f.services.Scheduler)
+	waitFor(t, "Message Scheduled", func() bool { return f.queue.Settlement(tag) == harness.Acked })
+	waitFor(t, "Message Sent", func() bool { return len(f.smtp.Messages()) == 1 })
+
+	if time.Now().Before(due) {
+		t.Errorf("Message Sent before [%s]", due)
+	}
+	waitFor(t, "Status Event", func() bool { return len(f.events(t)) == 1 })
+	f.status(t, events.StatusSent)
+}
+
+func TestPollerDirectory(t *testing.T) {
+	f := newFixture(t)
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "m1.json"), []byte(`{"template": "welcome", "to": "a@example.com", "params": {"name": "Ana"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := source.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No Queue Server: No Events or Dead Letters
+	f.config.Events, f.config.DeadLetter = nil, nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Poller(ctx, f.config, src, nil, f.services)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	waitFor(t, "Message Sent", func() bool { return len(f.smtp.Messages()) == 1 })
+	waitFor(t, "Request File Removed", func() bool {
+		entries, _ := os.ReadDir(dir)
+		return len(entries) == 1 // Only 'rejected'
+	})
+}
+
+func TestServicesPrune(t *testing.T) {
+	// Keys Expire as Soon as Recorded
+	store, err := dedup.Open(&config.Deduplication{Path: filepath.Join(t.TempDir(), "dedup.db"), TTL: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Disabled Stores are Skipped
+	s := &Services{Dedup: store}
+	store.Record("id:m1")
+	store.Record("id:m2")
+
+	if n := s.Prune(); n != 2 {
+		t.Errorf("Pruned [%d] Expected [2]", n)
+	}
+
+	if n := s.Prune(); n != 0 {
+		t.Errorf("Pruned [%d] Expected [0]", n)
+	}
+}
+
+func TestSpoolGiveUp(t *testing.T) {
+	f := newFixture(t)
+	f.config.Spool = &config.Spool{Path: t.TempDir(), RetryInterval: 1, RetryMaxInterval: 1, MaxAttempts: 1}
+
+	var err error
+	f.services.Spool, err = spool.Open(f.config.Spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// AMQP Message ID Differs from Queue Message ID
+	f.smtp.Respond("MAIL", 421, "Service not available")
+	f.queue.Push("spooled", "amqp-1", request(t, "m1", map[string]interface{}{"template": "welcome", "to": "a@example.com", "params": map[string]interface{}{"name": "Ana"}}))
+	d, _ := f.queue.QueueRetrieve("read", "spooled")
+	if err = f.services.Spool.Put(d); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err := f.services.Spool.Due(time.Now())
+	if (err != nil) || (len(items) != 1) {
+		t.Fatalf("Spooled Messages [%d] Expected [1] [%v]", len(items), err)
+	}
+	sendSpooled(context.Background(), f.config, f.services, f.services.Spool, f.publisher, f.dead, items[0])
+
+	// Final Event Identifies the Message as Processing did
+	l := f.events(t)
+	if len(l) != 2 {
+		t.Fatalf("Events [%d] Expected [2]", len(l))
+	}
+	if e := l[1]; (e.ID != "m1") || (e.Status != events.StatusFailed) || (e.Template != "welcome") || (e.To != "a@example.com") {
+		t.Errorf("Unexpected Event %+v", e)
+	}
+
+	// Status Record Updated (not a New One)
+	r, err := f.services.Status.Get("m1")
+	if (err != nil) || (r == nil) {
+		t.Fatalf("Status Record not Found [%v]", err)
+	}
+	if (r.Stage != "failed") || (r.Template != "welcome") || (r.To != "a@example.com") {
+		t.Errorf("Unexpected Record %+v", r)
+	}
+	if r, _ := f.services.Status.Get("amqp-1"); r != nil {
+		t.Errorf("Unexpected Record %+v", r)
+	}
+
+	if dl := f.deadLetters(t); len(dl) != 1 {
+		t.Errorf("Dead Letters [%d] Expected [1]", len(dl))
+	}
+}
@@ -11,15 +11,30 @@ package poller
  */
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/streadway/amqp"
 
 	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-smtp-mailer/address"
 	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/deadletter"
+	"github.com/objectvault/queue-smtp-mailer/dedup"
+	"github.com/objectvault/queue-smtp-mailer/events"
 	"github.com/objectvault/queue-smtp-mailer/mailer"
+	"github.com/objectvault/queue-smtp-mailer/ratelimit"
+	"github.com/objectvault/queue-smtp-mailer/schema"
+	"github.com/objectvault/queue-smtp-mailer/status"
+	"github.com/objectvault/queue-smtp-mailer/suppression"
+	"github.com/objectvault/queue-smtp-mailer/window"
 )
 
 func extractEmailMesssage(msg *amqp.Delivery) (*messages.QueueMessage, error) {
@@ -36,6 +51,19 @@ func extractEmailMesssage(msg *amqp.Delivery) (*messages.QueueMessage, error) {
 	return &queueMessage, nil
 }
 
+// setParameter Set Template Parameter Preserving JSON Type
+func setParameter(message *messages.EmailMessage, k string, v interface{}) {
+	// Do we have a Parameters Map?
+	params := message.GetParameters()
+	if params == nil { // NO: Create it
+		message.SetParameter(k, "")
+		params = message.GetParameters()
+	}
+
+	// NOTE: SetParameter only Accepts Strings, Numbers, Booleans, Lists and Objects are Set Directly
+	(*params)[k] = v
+}
+
 func setEmailMaps(message *messages.EmailMessage, s map[string]interface{}, d string) error {
 	var err error
 	for k, v := range s {
@@ -43,12 +71,13 @@ func setEmailMaps(message *messages.EmailMessage, s map[string]interface{}, d st
 		k = strings.ToLower(k)
 		switch d {
 		case "params":
-			err = message.SetParameter(k, v.(string))
+			// NOTE: Null Values are Kept for Schema Validation (but not Passed to Templates)
+			setParameter(message, k, v)
 		case "headers":
 			// Is Value a String?
 			s, ok := v.(string)
-			if !ok { // NO: Skip
-				continue
+			if !ok { // NO: Invalid Header
+				return fmt.Errorf("Invalid Value for Header [%s]", k)
 			}
 			err = message.SetHeader(k, s)
 		}
@@ -61,6 +90,33 @@ func setEmailMaps(message *messages.EmailMessage, s map[string]interface{}, d st
 	return err
 }
 
+// Latest Supported Request Version
+const requestVersion = 2
+
+// requestVersionOf Request Format Version (1 if not Set)
+//
+// Version 1: Unknown Fields are Template Parameters
+// Version 2: Parameters only in 'params', Unknown Fields are Errors
+func requestVersionOf(source map[string]interface{}) (int, error) {
+	for k, v := range source {
+		if strings.ToLower(k) != "version" {
+			continue
+		}
+
+		n, castOK := v.(float64)
+		if !castOK || (n != float64(int(n))) || (n < 1) {
+			return 0, errors.New("Invalid Value for 'version' field")
+		}
+
+		if int(n) > requestVersion {
+			return 0, fmt.Errorf("Unsupported Request Version [%d]", int(n))
+		}
+		return int(n), nil
+	}
+
+	return 1, nil
+}
+
 func toEmailMessage(source *map[string]interface{}) (*messages.EmailMessage, error) {
 
 	// Create
@@ -68,11 +124,21 @@ func toEmailMessage(source *map[string]interface{}) (*messages.EmailMessage, err
 
 	params := make(map[string]interface{})
 
+	// Normalized Addresses (EmailMessage Setters Lower Case them)
+	var to, from, cc, bcc string
+
+	// Request Format Version
+	version, err := requestVersionOf(*source)
+	if err != nil {
+		return nil, err
+	}
+	message.SetVersion(version)
+
 	// Range through the MAP and Set Message Properties
-	var err error
 	for k, v := range *source {
 		k = strings.ToLower(k)
 		switch k {
+		case "version": // Already Handled
 		case "template":
 			s, castOK := v.(string)
 			if castOK {
@@ -90,28 +156,40 @@ func toEmailMessage(source *map[string]interface{}) (*messages.EmailMessage, err
 		case "to":
 			s, castOK := v.(string)
 			if castOK {
-				_, err = message.SetTo(s)
+				to, err = address.Normalize(s)
+				if err == nil {
+					_, err = message.SetTo(to)
+				}
 			} else {
 				err = errors.New("Invalid Value for 'to' field")
 			}
 		case "from":
 			s, castOK := v.(string)
 			if castOK {
-				_, err = message.SetFrom(s)
+				from, err = address.Normalize(s)
+				if err == nil {
+					_, err = message.SetFrom(from)
+				}
 			} else {
 				err = errors.New("Invalid Value for 'from' field")
 			}
 		case "cc":
 			s, castOK := v.(string)
 			if castOK {
-				_, err = message.SetCC(s)
+				cc, err = address.NormalizeList(s)
+				if err == nil {
+					_, err = message.SetCC(cc)
+				}
 			} else {
 				err = errors.New("Invalid Value for 'cc' field")
 			}
 		case "bcc":
 			s, castOK := v.(string)
 			if castOK {
-				_, err = message.SetBCC(s)
+				bcc, err = address.NormalizeList(s)
+				if err == nil {
+					_, err = message.SetBCC(bcc)
+				}
 			} else {
 				err = errors.New("Invalid Value for 'bcc' field")
 			}
@@ -130,9 +208,11 @@ func toEmailMessage(source *map[string]interface{}) (*messages.EmailMessage, err
 				err = errors.New("Invalid Value for 'headers' field")
 			}
 		default: // Add Value to Parameters List
-			s, castOK := v.(string)
-			if castOK {
-				params[k] = s
+			// Are Parameters Allowed Outside 'params'?
+			if version > 1 { // NO
+				err = fmt.Errorf("Unknown Field [%s]", k)
+			} else {
+				params[k] = v
 			}
 		}
 
@@ -150,46 +230,526 @@ func toEmailMessage(source *map[string]interface{}) (*messages.EmailMessage, err
 		return nil, errors.New("Invalid Email Message Request")
 	}
 
+	err = setAddresses(&message, to, from, cc, bcc)
+	if err != nil {
+		return nil, err
+	}
+
 	return &message, nil
 }
 
-func process(c *config.DaemonConfig, d *amqp.Delivery) error {
+// setAddresses Set Message Addresses Keeping the Local Part Case
+//
+// EmailMessage setters lower case the whole address, but its JSON decoding does
+// not, so addresses are set through it. Parameters and headers are copied over
+// as they are, to keep their types.
+func setAddresses(m *messages.EmailMessage, to string, from string, cc string, bcc string) error {
+	params, headers := m.GetParameters(), m.GetHeaders()
+
+	fields := map[string]interface{}{
+		"version":  m.Version(),
+		"template": m.Template(),
+		"locale":   m.Language(),
+		"to":       to,
+		"from":     from,
+		"cc":       cc,
+		"bcc":      bcc,
+		"params":   map[string]interface{}{},
+	}
+	if headers != nil {
+		fields["headers"] = map[string]string{}
+	}
+
+	b, err := json.Marshal(fields)
+	if err == nil {
+		err = m.UnmarshalJSON(b)
+	}
+	if err != nil {
+		return err
+	}
+
+	if params != nil {
+		for k, v := range *params {
+			(*m.GetParameters())[k] = v
+		}
+	}
+
+	if headers != nil {
+		for k, v := range *headers {
+			(*m.GetHeaders())[k] = v
+		}
+	}
+	return nil
+}
+
+// ParseRequest Decode Email Request JSON (Bare Request or Queue Message Wrapping One)
+//
+// Requests are converted exactly as when received from the queue.
+func ParseRequest(b []byte) (*messages.EmailMessage, error) {
+	source := map[string]interface{}{}
+	err := json.Unmarshal(b, &source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Is it a Queue Message?
+	if inner, ok := source["message"].(map[string]interface{}); ok && (source["id"] != nil) { // YES: Unwrap Request
+		source = inner
+	}
+
+	// Idempotency Keys and Delivery Options are not Template Parameters
+	delete(source, "idempotency-key")
+	_, err = deliveryOptions(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return toEmailMessage(&source)
+}
+
+// Delivery Options (Request Fields that are not Part of the Email)
+type delivery struct {
+	sendAt    time.Time      // Not Before (Zero if Not Set)
+	expiresAt time.Time      // Not After (Zero if Not Set)
+	location  *time.Location // Recipient Timezone (nil if Not Set)
+}
+
+// deliveryOptions Remove and Parse 'send_at', 'expires_at' and 'timezone' Fields
+func deliveryOptions(source map[string]interface{}) (*delivery, error) {
+	o := &delivery{}
+	for _, k := range []string{"send_at", "expires_at", "timezone"} {
+		v, ok := source[k]
+		if !ok {
+			continue
+		}
+		delete(source, k)
+
+		s, castOK := v.(string)
+		if !castOK {
+			return nil, fmt.Errorf("Invalid Value for '%s' field", k)
+		}
+
+		// Timezone?
+		if k == "timezone" { // YES
+			l, err := time.LoadLocation(s)
+			if (err != nil) || (s == "") {
+				return nil, fmt.Errorf("Invalid Value for 'timezone' field [%s]", s)
+			}
+			o.location = l
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Value for '%s' field (expected RFC3339)", k)
+		}
+
+		if k == "send_at" {
+			o.sendAt = t
+		} else {
+			o.expiresAt = t
+		}
+	}
+
+	// Does the Message Expire before it can be Sent?
+	if !o.sendAt.IsZero() && !o.expiresAt.IsZero() && !o.expiresAt.After(o.sendAt) { // YES
+		return nil, errors.New("Field 'expires_at' is not after 'send_at'")
+	}
+
+	return o, nil
+}
+
+// nextSlot Earliest Time Message can be Sent within its Template's Delivery Window
+//
+// The recipient's timezone is the 'timezone' request field, or the 'timezone'
+// template parameter, or the window's timezone (UTC if none).
+func nextSlot(c *config.DaemonConfig, msg *messages.EmailMessage, o *delivery, now time.Time) time.Time {
+	// Is the Template Restricted to a Delivery Window?
+	cw := c.Windows[msg.Template()]
+	if cw == nil { // NO: Send Now (Transactional)
+		return now
+	}
+
+	w, err := window.Parse(cw.Start, cw.End)
+	if err != nil { // Validated on Load
+		log.Print(err)
+		return now
+	}
+
+	location := o.location
+	if params := msg.GetParameters(); (location == nil) && (params != nil) {
+		if tz, ok := (*params)["timezone"].(string); ok && (tz != "") {
+			location, err = time.LoadLocation(tz)
+			if err != nil {
+				log.Printf("Ignoring Invalid Timezone Parameter [%s]", tz)
+			}
+		}
+	}
+	if location == nil {
+		location, _ = time.LoadLocation(cw.Timezone)
+	}
+
+	return w.Next(now.In(location))
+}
+
+// expired Has Expiry Time Passed?
+func expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
+
+// hold Move Message to Scheduler until Due
+func hold(services *Services, p *events.Publisher, d *amqp.Delivery, event *events.Event, at time.Time) error {
+	err := services.Scheduler.PutAt(d, at)
+	if err != nil { // Leave for Redelivery
+		log.Printf("Failed to Schedule Message [%s] [%s]", event.ID, err)
+		if e := d.Nack(false, true); e != nil {
+			log.Print(e)
+		}
+		report(services, p, event.Complete(events.StatusDeferred, err))
+		return err
+	}
+
+	log.Printf("Message [%s] Scheduled for [%s]", event.ID, at.Format(time.RFC3339))
+	err = d.Ack(false)
+	if err != nil {
+		log.Print(err)
+	}
+
+	if err := services.Status.Track(event, status.Scheduled); err != nil {
+		log.Printf("Failed to Record Status of Message [%s] [%s]", event.ID, err)
+	}
+	return nil
+}
+
+// NewMessageID Random Message ID
+func NewMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ToQueueMessage Validate Request and Wrap it as Queue Message
+//
+// Requests already wrapped as queue messages keep their ID, others get a new one.
+func ToQueueMessage(c *config.DaemonConfig, b []byte) (*messages.QueueMessage, error) {
+	// Validate Request as the Daemon Would
+	msg, err := ParseRequest(b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mailer.ValidateParameters(c, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	source := map[string]interface{}{}
+	json.Unmarshal(b, &source)
+
+	// Is it Already a Queue Message?
+	id := NewMessageID()
+	if inner, ok := source["message"].(map[string]interface{}); ok && (source["id"] != nil) { // YES: Keep ID
+		if s, ok := source["id"].(string); ok && (s != "") {
+			id = s
+		}
+		source = inner
+	}
+
+	qm := &messages.QueueMessage{}
+	qm.SetVersion(1)
+	_, err = qm.SetID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = qm.SetMessage(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return qm, nil
+}
+
+// unsuppressed Remove Suppressed Addresses from Address List
+func unsuppressed(l *suppression.List, list string) string {
+	if (l == nil) || (list == "") {
+		return list
+	}
+
+	var keep []string
+	for _, a := range strings.Split(list, ";") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+
+		if e := l.Lookup(a); e != nil {
+			log.Printf("Dropping Suppressed Copy [%s]", e.Address)
+			continue
+		}
+
+		keep = append(keep, a)
+	}
+
+	return strings.Join(keep, ";")
+}
+
+// report Record Event in Status Store and Publish it
+func report(services *Services, p *events.Publisher, e *events.Event) {
+	if err := services.Status.Track(e, status.Stage(e.Status)); err != nil {
+		log.Printf("Failed to Record Status of Message [%s] [%s]", e.ID, err)
+	}
+
+	p.Publish(e)
+}
+
+// discard Remove Message from Queue (Retrying will not Help) and Publish Final Status
+//
+// Rejected and Failed Messages are Parked in the Dead Letter Queue (if Configured).
+// Messages that can't be Parked are Returned for Redelivery, rather than Lost.
+func discard(services *Services, dl *deadletter.Queue, p *events.Publisher, d *amqp.Delivery, e *events.Event, s events.Status, err error) {
+	e.Complete(s, err)
+
+	// Should Message be Kept for Inspection/Replay?
+	if (s != events.StatusSuppressed) && (s != events.StatusExpired) { // YES
+		if perr := dl.Park(d, e); perr != nil { // Keep Message until it can be Parked
+			log.Printf("Failed to Park Message [%s] as Dead Letter [%s]", e.ID, perr)
+			if nerr := d.Nack(false, true); nerr != nil {
+				log.Print(nerr)
+			}
+
+			report(services, p, e.Complete(events.StatusDeferred, perr))
+			return
+		}
+	}
+
+	err = d.Reject(false)
+	if err != nil {
+		log.Print(err)
+	}
+
+	report(services, p, e)
+}
+
+func process(ctx context.Context, c *config.DaemonConfig, services *Services, p *events.Publisher, dl *deadletter.Queue, d *amqp.Delivery) error {
+	// Delivery Status Event
+	event := events.NewEvent(d.MessageId)
+
 	// STEP 1: Extract Queue Message //
 	msg, err := extractEmailMesssage(d)
 	if err != nil {
 		log.Print("Queue Message is Invalid")
+		discard(services, dl, p, d, event, events.StatusRejected, err)
+		return err
 	}
 
 	log.Printf("Processing Message [%s]", msg.ID())
+	event.ID = msg.ID()
+	if created := msg.Created(); created != nil {
+		event.Created = created.UTC().Format(time.RFC3339)
+	}
+
+	err = services.Status.Track(event, status.Received)
+	if err != nil {
+		log.Printf("Failed to Record Status of Message [%s] [%s]", event.ID, err)
+	}
 
 	// STEP 2: Extract Email Request //
 	i := msg.Message()
 
 	// Is Valid Message Format?
-	s, ok := (*i).(map[string]interface{})
-	if !ok { // NO
-		return errors.New("Invalid Massage Format")
+	var s map[string]interface{}
+	if i != nil {
+		s, _ = (*i).(map[string]interface{})
+	}
+	if s == nil { // NO
+		err = errors.New("Invalid Massage Format")
+		discard(services, dl, p, d, event, events.StatusRejected, err)
+		return err
+	}
+
+	// Producer Supplied Idempotency Key? (Not a Template Parameter)
+	idempotency, _ := s["idempotency-key"].(string)
+	delete(s, "idempotency-key")
+
+	// Delivery Options (Not Template Parameters)
+	options, err := deliveryOptions(s)
+	if err != nil {
+		log.Print(err)
+		discard(services, dl, p, d, event, events.StatusRejected, err)
+		return err
+	}
+
+	// Has the Message Already been Sent?
+	key := dedup.Key(msg.ID(), idempotency)
+	sent, err := services.Dedup.Seen(key)
+	if err != nil { // UNKNOWN: Better to Risk a Duplicate than Lose the Message
+		log.Printf("Deduplication Check Failed [%s]", err)
+	} else if sent { // YES: Remove from Queue
+		log.Printf("Message [%s] Already Sent. Skipping...", msg.ID())
+		err = d.Ack(false)
+		if err != nil {
+			log.Print(err)
+		}
+		return nil
 	}
 
 	// Import Message Date into Object
 	emailMessage, err := toEmailMessage(&s)
 	if err != nil {
 		log.Print(err)
+		discard(services, dl, p, d, event, events.StatusRejected, err)
 		return err
 	}
 
-	// STEP 3: Try to Send Email
-	err = mailer.SendMail(c, emailMessage)
+	event.Template = emailMessage.Template()
+	event.To = emailMessage.To()
+	event.Relay = mailer.Relay(c)
+
+	// Do Parameters Match the Template Schema?
+	err = mailer.ValidateParameters(c, emailMessage)
 	if err != nil {
 		log.Print(err)
+
+		// Is the Request Invalid?
+		var invalid schema.Errors
+		if errors.As(err, &invalid) { // YES: Remove from Queue
+			discard(services, dl, p, d, event, events.StatusRejected, err)
+		} else { // NO: Bad Schema File - Leave for Redelivery
+			report(services, p, event.Complete(events.StatusDeferred, err))
+		}
+		return err
+	}
+
+	// Can Recipient Domains Receive Mail?
+	recipients := strings.Split(emailMessage.To()+";"+emailMessage.CC()+";"+emailMessage.BCC(), ";")
+	err = services.Validator.Check(recipients...)
+	if err != nil { // NO
+		log.Print(err)
+		discard(services, dl, p, d, event, events.StatusRejected, err)
+		return err
+	}
+
+	// Has the Message Expired?
+	if expired(options.expiresAt) { // YES: Sending it Late is Pointless
+		err = fmt.Errorf("Message Expired at [%s]", options.expiresAt.Format(time.RFC3339))
+		log.Print(err)
+		discard(services, dl, p, d, event, events.StatusExpired, err)
 		return err
 	}
 
-	// STEP 4: Acknowledge Message so it's removed from Queue
+	// Is the Message Scheduled for Later?
+	if time.Now().Before(options.sendAt) { // YES: Hold it until Due
+		// Can we Hold Messages?
+		if services.Scheduler == nil { // NO
+			err = errors.New("Scheduled Delivery ('send_at') is not Configured")
+			log.Print(err)
+			discard(services, dl, p, d, event, events.StatusRejected, err)
+			return err
+		}
+
+		return hold(services, p, d, event, options.sendAt)
+	}
+
+	// Is it Outside the Template's Delivery Window?
+	now := time.Now()
+	if slot := nextSlot(c, emailMessage, options, now); slot.After(now) { // YES: Hold it until Window Opens
+		log.Printf("Message [%s] Outside Delivery Window for Template [%s]", msg.ID(), emailMessage.Template())
+
+		// Can we Hold Messages?
+		if services.Scheduler == nil { // NO
+			err = errors.New("Delivery Windows require a Scheduler")
+			log.Print(err)
+			discard(services, dl, p, d, event, events.StatusRejected, err)
+			return err
+		}
+
+		return hold(services, p, d, event, slot)
+	}
+
+	// STEP 3: Check Suppression List
+	if !services.Suppression.Exempt(emailMessage.Template()) {
+		// Is the Destination Suppressed?
+		if e := services.Suppression.Lookup(emailMessage.To()); e != nil { // YES: Never Send
+			err = fmt.Errorf("Recipient [%s] is Suppressed [%s]", e.Address, e.Reason)
+			log.Print(err)
+			discard(services, dl, p, d, event, events.StatusSuppressed, err)
+			return err
+		}
+
+		// Drop Suppressed Copies
+		cc := unsuppressed(services.Suppression, emailMessage.CC())
+		bcc := unsuppressed(services.Suppression, emailMessage.BCC())
+		setAddresses(emailMessage, emailMessage.To(), emailMessage.From(""), cc, bcc)
+	}
+
+	// STEP 4: Wait for Send Slot
+	domains := ratelimit.Domains(emailMessage.To(), emailMessage.CC(), emailMessage.BCC())
+	err = services.Limiter.Wait(ctx, domains)
+	if err != nil { // Limited or Shutting Down: Requeue to Send Later
+		if e := d.Nack(false, true); e != nil {
+			log.Print(e)
+		}
+		report(services, p, event.Complete(events.StatusDeferred, err))
+		return err
+	}
+
+	// Did the Message Expire while Waiting?
+	if expired(options.expiresAt) { // YES
+		err = fmt.Errorf("Message Expired at [%s]", options.expiresAt.Format(time.RFC3339))
+		log.Print(err)
+		discard(services, dl, p, d, event, events.StatusExpired, err)
+		return err
+	}
+
+	// STEP 5: Try to Send Email (Rendered Once, so the Archive Holds the Bytes Sent)
+	mime, err := mailer.RenderMIME(c, emailMessage)
+	if err == nil {
+		if e := services.Status.Track(event, status.Rendered); e != nil {
+			log.Printf("Failed to Record Status of Message [%s] [%s]", event.ID, e)
+		}
+
+		event.Response, err = mailer.SendMIME(c, emailMessage, mime)
+	}
+	if err != nil {
+		event.Response = mailer.Response(err)
+		log.Print(err)
+
+		// Is it a Permanent Failure?
+		if mailer.IsPermanent(err) { // YES: Remove from Queue
+			// Was the Only Recipient Rejected?
+			if mailer.IsBadRecipient(err) && (emailMessage.CC() == "") && (emailMessage.BCC() == "") { // YES: Suppress it
+				e := services.Suppression.Add(emailMessage.To(), event.Response)
+				if e != nil {
+					log.Printf("Failed to Suppress [%s] [%s]", emailMessage.To(), e)
+				}
+			}
+
+			discard(services, dl, p, d, event, events.StatusFailed, err)
+		} else { // NO: Leave Unacknowledged for Redelivery
+			report(services, p, event.Complete(events.StatusDeferred, err))
+		}
+		return err
+	}
+
+	// Keep Copy of Message Sent
+	err = services.Archive.Store(msg.ID(), emailMessage.To(), mime)
+	if err != nil {
+		log.Printf("Failed to Archive Message [%s] [%s]", msg.ID(), err)
+	}
+
+	// Remember Message was Sent (in Case it is Redelivered)
+	err = services.Dedup.Record(key)
+	if err != nil {
+		log.Printf("Failed to Record Message [%s] as Sent [%s]", msg.ID(), err)
+	}
+
+	// STEP 6: Acknowledge Message so it's removed from Queue
 	err = d.Ack(false)
 	if err != nil {
 		log.Print(err)
 	}
 
+	report(services, p, event.Complete(events.StatusSent, nil))
 	return nil
 }
@@ -0,0 +1,658 @@
+package poller
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-interface/shared"
+	"github.com/objectvault/queue-smtp-mailer/archive"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/deadletter"
+	"github.com/objectvault/queue-smtp-mailer/events"
+	"github.com/objectvault/queue-smtp-mailer/internal/harness"
+	"github.com/objectvault/queue-smtp-mailer/mailer"
+	"github.com/objectvault/queue-smtp-mailer/spool"
+	"github.com/objectvault/queue-smtp-mailer/status"
+)
+
+// fixture Mailer Wired to Test SMTP Server and In-Memory Queue
+type fixture struct {
+	smtp      *harness.SMTPServer
+	queue     *harness.Queue
+	config    *config.DaemonConfig
+	services  *Services
+	publisher *events.Publisher
+	dead      *deadletter.Queue
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	server, err := harness.NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	// Templates
+	dir := t.TempDir()
+	templates := map[string]string{
+		"welcome.text.template":    "Hello {{.name}}\n{{range .items}}- {{.}}\n{{end}}",
+		"welcome.pt.text.template": "Olá {{.name}}\n",
+		"welcome.html.template":    "<p>{{.name}}</p>",
+	}
+	for name, content := range templates {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.DaemonConfig{
+		SMTPRelay: &config.SMTPRelay{
+			Server: &shared.Server{Host: server.Host(), Port: server.Port()},
+		},
+		Paths: &config.Paths{Templates: dir, Temporary: t.TempDir()},
+		Options: &config.Options{
+			PollMaxMessages: 10,
+			PollInterval:    1,
+			PollQueue:       "inbox",
+			ShutdownGrace:   5,
+		},
+		Events:     &config.Events{Queue: "events"},
+		DeadLetter: &config.DeadLetter{Queue: "dead-letter"},
+	}
+
+	f := &fixture{
+		smtp:     server,
+		queue:    harness.NewQueue(),
+		config:   c,
+		services: &Services{},
+	}
+
+	f.publisher, err = events.NewPublisher(c.Events, f.queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.dead, err = deadletter.Open(c.DeadLetter, f.queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.services.Status, err = status.Open(&config.Status{Path: filepath.Join(t.TempDir(), "status.db"), Retention: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.services.Status.Close() })
+
+	f.services.Archive, err = archive.Open(&config.Archive{
+		Path:      filepath.Join(t.TempDir(), "archive"),
+		Key:       base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		Retention: 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+// request Wrap Email Request as Queue Message
+func request(t *testing.T, id string, r map[string]interface{}) []byte {
+	t.Helper()
+
+	qm := &messages.QueueMessage{}
+	qm.SetVersion(1)
+	qm.SetID(id)
+	qm.SetMessage(r)
+
+	b, err := json.Marshal(qm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// process Push Message Body to Poll Queue and Process it, Returning the Delivery Tag
+func (f *fixture) process(t *testing.T, id string, body []byte) uint64 {
+	t.Helper()
+
+	tag := f.queue.Push(f.config.Options.PollQueue, id, body)
+	d, err := f.queue.QueueRetrieve("read", f.config.Options.PollQueue)
+	if (err != nil) || (d == nil) {
+		t.Fatalf("Retrieve Failed [%v]", err)
+	}
+
+	process(context.Background(), f.config, f.services, f.publisher, f.dead, d)
+	return tag
+}
+
+// events Status Events Published
+func (f *fixture) events(t *testing.T) []*events.Event {
+	t.Helper()
+
+	var l []*events.Event
+	for _, p := range f.queue.Published("") {
+		if p.Key != "events" {
+			continue
+		}
+
+		e := &events.Event{}
+		if err := json.Unmarshal(p.Message.Body, e); err != nil {
+			t.Fatal(err)
+		}
+		l = append(l, e)
+	}
+	return l
+}
+
+// deadLetters Messages Parked in the Dead Letter Queue
+func (f *fixture) deadLetters(t *testing.T) []*deadletter.Entry {
+	t.Helper()
+
+	var l []*deadletter.Entry
+	for {
+		d, _ := f.queue.QueueRetrieve("dlq", "dead-letter")
+		if d == nil {
+			return l
+		}
+
+		e, err := deadletter.Decode(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l = append(l, e)
+	}
+}
+
+// status Check Single Event Published with Status
+func (f *fixture) status(t *testing.T, want events.Status) *events.Event {
+	t.Helper()
+
+	l := f.events(t)
+	if len(l) != 1 {
+		t.Fatalf("Events [%d] Expected [1]", len(l))
+	}
+
+	if l[0].Status != want {
+		t.Fatalf("Status [%s] Expected [%s] (Error [%s])", l[0].Status, want, l[0].Error)
+	}
+	return l[0]
+}
+
+func TestProcessSent(t *testing.T) {
+	f := newFixture(t)
+	f.smtp.Respond("DATA", 250, "2.0.0 Ok: queued as 4F2A")
+
+	tag := f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"version":  2,
+		"template": "welcome",
+		"to":       "User <USER@Example.com>",
+		"params": map[string]interface{}{
+			"name":  "Ana",
+			"items": []string{"one", "two"},
+		},
+	}))
+
+	if s := f.queue.Settlement(tag); s != harness.Acked {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Acked)
+	}
+
+	e := f.status(t, events.StatusSent)
+	if (e.ID != "m1") || (e.Template != "welcome") || (e.Response != "250 2.0.0 Ok: queued as 4F2A") {
+		t.Errorf("Unexpected Event %+v", e)
+	}
+
+	sent := f.smtp.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("Messages Sent [%d] Expected [1]", len(sent))
+	}
+
+	// Only the Domain is Case Insensitive
+	if (len(sent[0].To) != 1) || (sent[0].To[0] != "USER@example.com") {
+		t.Errorf("Recipients %v Expected [USER@example.com]", sent[0].To)
+	}
+
+	if !strings.Contains(sent[0].Data, "To: USER@example.com") {
+		t.Errorf("Message not Addressed to [USER@example.com]:\n%s", sent[0].Data)
+	}
+
+	for _, want := range []string{"Hello Ana", "- one", "- two", "<p>Ana</p>"} {
+		if !strings.Contains(sent[0].Data, want) {
+			t.Errorf("Message Missing [%s]:\n%s", want, sent[0].Data)
+		}
+	}
+
+	if l := f.deadLetters(t); len(l) != 0 {
+		t.Errorf("Dead Letters [%d] Expected [0]", len(l))
+	}
+
+	// Copy Archived Exactly as Sent (Same Date and MIME Boundaries)
+	b, err := f.services.Archive.Get("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: SMTP Server Reads Lines with CRLF Converted
+	if archived := strings.ReplaceAll(string(b), "\r\n", "\n"); strings.TrimRight(archived, "\n") != strings.TrimRight(sent[0].Data, "\n") {
+		t.Errorf("Archived Message Differs from Message Sent:\n%s\n---\n%s", archived, sent[0].Data)
+	}
+}
+
+func TestProcessLocale(t *testing.T) {
+	f := newFixture(t)
+
+	f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"template": "welcome",
+		"locale":   "pt_BR",
+		"to":       "user@example.com",
+		"name":     "Ana",
+	}))
+
+	f.status(t, events.StatusSent)
+	sent := f.smtp.Messages()
+	if (len(sent) != 1) || !strings.Contains(sent[0].Data, "Ol=C3=A1 Ana") {
+		t.Fatalf("Expected Portuguese Text Part:\n%v", sent)
+	}
+}
+
+func TestProcessRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   []byte
+		reason string
+	}{
+		{"not json", []byte("not json"), "invalid character"},
+		{"no recipient", request(t, "m1", map[string]interface{}{"template": "welcome"}), "Invalid Email Message Request"},
+		{"bad address", request(t, "m1", map[string]interface{}{"template": "welcome", "to": "user@"}), "user@"},
+		{"unknown field", request(t, "m1", map[string]interface{}{"version": 2, "template": "welcome", "to": "user@example.com", "name": "Ana"}), "Unknown Field [name]"},
+		{"bad header", request(t, "m1", map[string]interface{}{"template": "welcome", "to": "user@example.com", "headers": map[string]interface{}{"x-id": 1}}), "Invalid Value for Header [x-id]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			tag := f.process(t, "m1", test.body)
+
+			if s := f.queue.Settlement(tag); s != harness.Rejected {
+				t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+			}
+
+			f.status(t, events.StatusRejected)
+			if len(f.smtp.Messages()) != 0 {
+				t.Fatal("Rejected Message was Sent")
+			}
+
+			l := f.deadLetters(t)
+			if len(l) != 1 {
+				t.Fatalf("Dead Letters [%d] Expected [1]", len(l))
+			}
+
+			if !strings.Contains(l[0].Reason, test.reason) {
+				t.Errorf("Reason [%s] Expected [%s]", l[0].Reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestProcessDeadLetterUnavailable(t *testing.T) {
+	f := newFixture(t)
+	f.queue.FailPublish("dead-letter", errors.New("channel closed"))
+
+	tag := f.process(t, "m1", []byte("not json"))
+
+	// Returned to the Queue, not Lost
+	if s := f.queue.Settlement(tag); s != harness.Requeued {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Requeued)
+	}
+
+	if e := f.status(t, events.StatusDeferred); !strings.Contains(e.Error, "channel closed") {
+		t.Errorf("Error [%s] Expected Park Failure", e.Error)
+	}
+
+	// Parked once the Dead Letter Queue is Back
+	f.queue.FailPublish("dead-letter", nil)
+	tag = f.process(t, "m1", []byte("not json"))
+	if s := f.queue.Settlement(tag); s != harness.Rejected {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+	}
+
+	if l := f.deadLetters(t); len(l) != 1 {
+		t.Errorf("Dead Letters [%d] Expected [1]", len(l))
+	}
+}
+
+func TestProcessTemporaryFailure(t *testing.T) {
+	f := newFixture(t)
+	f.smtp.Respond("RCPT", 451, "Try again later")
+
+	tag := f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana"},
+	}))
+
+	// Left Unacknowledged for Redelivery
+	if s := f.queue.Settlement(tag); s != harness.Unsettled {
+		t.Fatalf("Settlement [%s] Expected Unsettled", s)
+	}
+
+	e := f.status(t, events.StatusDeferred)
+	if !strings.HasPrefix(e.Response, "451") {
+		t.Errorf("Response [%s] Expected [451]", e.Response)
+	}
+
+	// Lifecycle Recorded
+	r, _ := f.services.Status.Get("m1")
+	if (r == nil) || (len(r.History) != 3) {
+		t.Fatalf("Unexpected Status Record %+v", r)
+	}
+	for i, want := range []status.Stage{status.Received, status.Rendered, "deferred"} {
+		if r.History[i].Stage != want {
+			t.Errorf("Stage [%d] [%s] Expected [%s]", i, r.History[i].Stage, want)
+		}
+	}
+
+	if l := f.deadLetters(t); len(l) != 0 {
+		t.Errorf("Dead Letters [%d] Expected [0]", len(l))
+	}
+}
+
+func TestProcessPermanentFailure(t *testing.T) {
+	f := newFixture(t)
+	f.smtp.Respond("RCPT", 550, "No such user")
+
+	tag := f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana"},
+	}))
+
+	if s := f.queue.Settlement(tag); s != harness.Rejected {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+	}
+
+	f.status(t, events.StatusFailed)
+
+	l := f.deadLetters(t)
+	if len(l) != 1 {
+		t.Fatalf("Dead Letters [%d] Expected [1]", len(l))
+	}
+
+	if (l[0].ID != "m1") || (l[0].Status != events.StatusFailed) || (l[0].Attempts != 1) || !strings.HasPrefix(l[0].Response, "550") {
+		t.Errorf("Unexpected Dead Letter %+v", l[0])
+	}
+
+	// Replayed Message Counts the Attempt
+	replay, _ := json.Marshal(l[0].Replay())
+	f.smtp.Reset()
+	f.process(t, "m1", replay)
+
+	l = f.deadLetters(t)
+	if (len(l) != 0) || (len(f.smtp.Messages()) != 1) {
+		t.Fatalf("Replay not Sent: Dead Letters [%d] Messages [%d]", len(l), len(f.smtp.Messages()))
+	}
+}
+
+func TestProcessExpired(t *testing.T) {
+	f := newFixture(t)
+
+	tag := f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"version":    2,
+		"template":   "welcome",
+		"to":         "user@example.com",
+		"params":     map[string]interface{}{"name": "Ana"},
+		"expires_at": time.Now().Add(-time.Minute).Format(time.RFC3339),
+	}))
+
+	if s := f.queue.Settlement(tag); s != harness.Rejected {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+	}
+
+	f.status(t, events.StatusExpired)
+	if len(f.smtp.Messages()) != 0 {
+		t.Fatal("Expired Message was Sent")
+	}
+
+	// Replaying would not Help
+	if l := f.deadLetters(t); len(l) != 0 {
+		t.Errorf("Dead Letters [%d] Expected [0]", len(l))
+	}
+}
+
+func TestProcessScheduled(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	body := request(t, "m1", map[string]interface{}{
+		"version":    2,
+		"template":   "welcome",
+		"to":         "user@example.com",
+		"params":     map[string]interface{}{"name": "Ana"},
+		"send_at":    later.Format(time.RFC3339),
+		"expires_at": later.Add(time.Hour).Format(time.RFC3339),
+	})
+
+	// No Scheduler: Rejected
+	f := newFixture(t)
+	tag := f.process(t, "m1", body)
+	if s := f.queue.Settlement(tag); s != harness.Rejected {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+	}
+
+	// Held in Scheduler until Due
+	f = newFixture(t)
+
+	var err error
+	f.services.Scheduler, err = spool.Open(&config.Spool{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag = f.process(t, "m1", body)
+	if s := f.queue.Settlement(tag); s != harness.Acked {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Acked)
+	}
+
+	if (len(f.smtp.Messages()) != 0) || (len(f.events(t)) != 0) {
+		t.Fatal("Scheduled Message Sent")
+	}
+
+	items, next, _ := f.services.Scheduler.Due(time.Now())
+	if (len(items) != 0) || (next.Unix() != later.Unix()) {
+		t.Fatalf("Scheduled for [%s] Expected [%s]", next, later)
+	}
+
+	if r, _ := f.services.Status.Get("m1"); (r == nil) || (r.Stage != status.Scheduled) {
+		t.Errorf("Unexpected Status Record %+v", r)
+	}
+}
+
+func TestProcessDeliveryWindow(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("No Timezone Database")
+	}
+
+	// Window Opening in Two Hours, Tokyo Time
+	start := (time.Now().In(tokyo).Hour() + 2) % 24
+	w := &config.DeliveryWindow{Start: fmt.Sprintf("%02d:00", start), End: fmt.Sprintf("%02d:00", (start+1)%24)}
+
+	f := newFixture(t)
+	f.config.Windows = map[string]*config.DeliveryWindow{"welcome": w}
+	f.services.Scheduler, err = spool.Open(&config.Spool{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag := f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"version":  2,
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana"},
+		"timezone": "Asia/Tokyo",
+	}))
+
+	if s := f.queue.Settlement(tag); s != harness.Acked {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Acked)
+	}
+
+	if len(f.smtp.Messages()) != 0 {
+		t.Fatal("Message Sent Outside Delivery Window")
+	}
+
+	_, next, _ := f.services.Scheduler.Due(time.Now())
+	if local := next.In(tokyo); (local.Hour() != start) || (local.Minute() != 0) || (time.Until(next) > 2*time.Hour) {
+		t.Errorf("Held until [%s] Expected [%02d:00] Tokyo Time", local, start)
+	}
+
+	// Templates without Window are Sent Immediately
+	f.config.Windows = map[string]*config.DeliveryWindow{"digest": w}
+	f.process(t, "m2", request(t, "m2", map[string]interface{}{
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana", "timezone": "Asia/Tokyo"},
+	}))
+
+	if len(f.smtp.Messages()) != 1 {
+		t.Fatal("Transactional Message not Sent")
+	}
+
+	// Windows Added (by Reload) without a Scheduler: Never Sent Outside the Window
+	f.config.Windows = map[string]*config.DeliveryWindow{"welcome": w}
+	f.services.Scheduler = nil
+	tag = f.process(t, "m3", request(t, "m3", map[string]interface{}{
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana", "timezone": "Asia/Tokyo"},
+	}))
+
+	if s := f.queue.Settlement(tag); s != harness.Rejected {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+	}
+
+	if len(f.smtp.Messages()) != 1 {
+		t.Fatal("Message Sent Outside Delivery Window")
+	}
+}
+
+func TestProcessSchema(t *testing.T) {
+	f := newFixture(t)
+
+	schema := `{"type": "object", "required": ["Name", "orderId"], "properties": {"orderId": {"type": "string"}, "Coupon": {"type": ["string", "null"]}}}`
+	err := os.WriteFile(filepath.Join(f.config.Paths.Templates, "welcome.schema.json"), []byte(schema), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag := f.process(t, "m1", request(t, "m1", map[string]interface{}{
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana", "OrderId": "A1", "coupon": nil},
+	}))
+	if s := f.queue.Settlement(tag); s != harness.Acked {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Acked)
+	}
+	f.status(t, events.StatusSent)
+
+	// Schema Still Enforced
+	tag = f.process(t, "m2", request(t, "m2", map[string]interface{}{
+		"template": "welcome",
+		"to":       "user@example.com",
+		"params":   map[string]interface{}{"name": "Ana", "orderId": 1},
+	}))
+	if s := f.queue.Settlement(tag); s != harness.Rejected {
+		t.Fatalf("Settlement [%s] Expected [%s]", s, harness.Rejected)
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	msg, err := ParseRequest([]byte(`{
+		"id": "m1",
+		"message": {
+			"template": "welcome",
+			"to": "user@example.com",
+			"total": 10.5,
+			"params": {"Items": [1, 2], "vip": true, "skip": null}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := *msg.GetParameters()
+	if v, ok := params["total"].(float64); !ok || (v != 10.5) {
+		t.Errorf("Parameter [total] [%v] Expected [10.5]", params["total"])
+	}
+
+	if v, ok := params["items"].([]interface{}); !ok || (len(v) != 2) {
+		t.Errorf("Parameter [items] [%v] Expected List", params["items"])
+	}
+
+	if v, ok := params["vip"].(bool); !ok || !v {
+		t.Errorf("Parameter [vip] [%v] Expected [true]", params["vip"])
+	}
+
+	// Null Values Validated against Schema, but not Passed to Templates
+	if v, ok := params["skip"]; !ok || (v != nil) {
+		t.Errorf("Null Parameter [skip] [%v] not Kept", v)
+	}
+
+	if _, ok := (*mailer.TemplateParameters(msg))["skip"]; ok {
+		t.Error("Null Parameter [skip] Passed to Templates")
+	}
+
+	// Local Parts Keep their Case
+	msg, err = ParseRequest([]byte(`{"template": "welcome", "to": "John.Doe@Example.COM", "from": "Shop <Sales@Shop.COM>", "cc": "Ana@Example.com; bob@example.com", "name": "John"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (msg.To() != "John.Doe@example.com") || (msg.From("") != "Sales@shop.com") || (msg.CC() != "Ana@example.com;bob@example.com") {
+		t.Errorf("Addresses [%s] [%s] [%s] not Normalized", msg.To(), msg.From(""), msg.CC())
+	}
+
+	if from, to := mailer.Envelope(msg); (from != "Sales@shop.com") || (len(to) != 1) || (to[0] != "John.Doe@example.com") {
+		t.Errorf("Envelope [%s] %v", from, to)
+	}
+
+	if msg.Parameter("name") != "John" {
+		t.Errorf("Parameter [name] [%v] Expected [John]", msg.Parameter("name"))
+	}
+
+	_, err = ParseRequest([]byte(`{"version": 3, "template": "welcome", "to": "user@example.com"}`))
+	if (err == nil) || !strings.Contains(err.Error(), "Unsupported Request Version") {
+		t.Errorf("Error [%v] Expected Unsupported Request Version", err)
+	}
+
+	// Delivery Times are not Parameters
+	msg, err = ParseRequest([]byte(`{"version": 2, "template": "welcome", "to": "user@example.com", "params": {"name": "Ana"}, "send_at": "2030-01-01T08:00:00Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{`"send_at": "tomorrow"`, `"expires_at": 10`, `"timezone": "Mars/Olympus"`, `"send_at": "2030-01-01T08:00:00Z", "expires_at": "2030-01-01T07:00:00Z"`} {
+		_, err = ParseRequest([]byte(`{"template": "welcome", "to": "user@example.com", "params": {"name": "Ana"}, ` + bad + `}`))
+		if err == nil {
+			t.Errorf("Invalid Delivery Times Accepted [%s]", bad)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package poller
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/deadletter"
+	"github.com/objectvault/queue-smtp-mailer/events"
+	"github.com/objectvault/queue-smtp-mailer/mailer"
+	"github.com/objectvault/queue-smtp-mailer/spool"
+)
+
+// spoolDelivery Move Delivery to Spool, Acknowledging it once Durably Written
+//
+// Returns false if the Delivery could not be Spooled (and should be Processed Directly).
+func spoolDelivery(s *spool.Spool, d *amqp.Delivery) bool {
+	err := s.Put(d)
+	if err != nil {
+		log.Printf("Failed to Spool Message [%s] [%s]", d.MessageId, err)
+		return false
+	}
+
+	// NOTE: If the Acknowledgement is Lost, the Redelivery is Spooled Again (Deduplication Store Avoids a Second Send)
+	err = d.Ack(false)
+	if err != nil {
+		log.Print(err)
+	}
+	return true
+}
+
+// retryDelay Exponential Backoff for Spooled Message Attempt
+func retryDelay(c *config.Spool, attempts int) time.Duration {
+	delay := time.Duration(c.RetryInterval) * time.Second
+	max := time.Duration(c.RetryMaxInterval) * time.Second
+	for i := 1; (i < attempts) && (delay < max); i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// settings Retry Settings for Spool (Outbox or Scheduler)
+func settings(c *config.DaemonConfig, s *Services, sp *spool.Spool) *config.Spool {
+	if sp == s.Scheduler {
+		return c.Scheduler
+	}
+	return c.Spool
+}
+
+// spooledEvent Status Event for Spooled Message, Identified as by process (Queue Message ID, Template and Recipient)
+func spooledEvent(c *config.DaemonConfig, d *amqp.Delivery) *events.Event {
+	event := events.NewEvent(d.MessageId)
+
+	// NOTE: Spooled Messages were Valid when Last Processed
+	msg, err := extractEmailMesssage(d)
+	if err != nil {
+		return event
+	}
+
+	event.ID = msg.ID()
+	if created := msg.Created(); created != nil {
+		event.Created = created.UTC().Format(time.RFC3339)
+	}
+
+	emailMessage, err := ParseRequest(d.Body)
+	if err == nil {
+		event.Template = emailMessage.Template()
+		event.To = emailMessage.To()
+		event.Relay = mailer.Relay(c)
+	}
+	return event
+}
+
+// sendSpooled Process Spooled Message, and Reschedule it if not Settled
+func sendSpooled(ctx context.Context, c *config.DaemonConfig, s *Services, sp *spool.Spool, p *events.Publisher, dl *deadletter.Queue, i *spool.Item) {
+	d := sp.Delivery(i)
+	process(ctx, c, s, p, dl, d)
+
+	retry := settings(c, s, sp)
+
+	var err error
+	switch i.Outcome() {
+	case spool.Done: // Sent, Rejected or Failed
+	case spool.Requeued: // Rate Limited or Shutting Down: Try Again Later
+		err = sp.Retry(i, time.Duration(c.Options.PollInterval)*time.Second, false)
+	case spool.Pending: // Temporary Failure
+		// Are we Out of Attempts?
+		if (retry.MaxAttempts > 0) && (i.Attempts+1 >= retry.MaxAttempts) { // YES: Give Up
+			event := spooledEvent(c, d)
+			discard(s, dl, p, d, event, events.StatusFailed, fmt.Errorf("Send Failed after [%d] Attempts", i.Attempts+1))
+
+			// Could the Message be Parked?
+			if i.Outcome() != spool.Requeued { // YES
+				return
+			}
+		}
+
+		err = sp.Retry(i, retryDelay(retry, i.Attempts+1), true)
+	}
+
+	if err != nil {
+		log.Printf("Failed to Reschedule Spooled Message [%s] [%s]", i.ID, err)
+	}
+}
+
+// Drain Send Spooled Messages (from Outbox or Scheduler) until Context is Cancelled
+//
+// Messages that fail temporarily are retried with exponential backoff, without
+// blocking the poller from reading the queue. Drain runs independently of the
+// Poller, so messages in the spool (including those recovered from previous
+// runs) are sent while the poll source is unavailable.
+//
+// Status events and dead letters are published with p and dl, which should not
+// depend on the poller's connection.
+func Drain(ctx context.Context, c *config.DaemonConfig, s *Services, sp *spool.Spool, p *events.Publisher, dl *deadletter.Queue) {
+	log.Print("START: Spool Sender")
+
+	for ctx.Err() == nil {
+		// Pick Up Reloaded Configuration
+		if current := config.Config(); (current != nil) && (settings(current, s, sp) != nil) {
+			c = current
+		}
+
+		items, next, err := sp.Due(time.Now())
+		if err != nil {
+			log.Printf("Failed to Read Spool [%s]", err)
+		}
+
+		for _, i := range items {
+			if ctx.Err() != nil {
+				break
+			}
+			sendSpooled(ctx, c, s, sp, p, dl, i)
+		}
+
+		// Wait for Next Message Due (or a New One)
+		wait := time.Duration(c.Options.PollInterval) * time.Second
+		if !next.IsZero() && (time.Until(next) < wait) {
+			wait = time.Until(next)
+		}
+
+		if len(items) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-sp.Wake():
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	log.Print("STOP: Spool Sender")
+}
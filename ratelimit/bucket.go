@@ -0,0 +1,87 @@
+package ratelimit
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// Token Bucket
+type bucket struct {
+	lock   sync.Mutex
+	rate   float64   // Tokens Added per Second
+	burst  float64   // Maximum Tokens in Bucket
+	tokens float64   // Available Tokens (Negative if Reserved in Advance)
+	last   time.Time // Last Time Tokens were Added
+}
+
+func newBucket(perMinute float64, burst int) *bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &bucket{
+		rate:   perMinute / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// limits Does the Bucket Enforce the Limit?
+func (b *bucket) limits(perMinute float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return (b.rate == perMinute/60) && (b.burst == float64(burst))
+}
+
+// reserve Take a Token, Returning How Long to Wait before Using it
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Refill Bucket
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	b.tokens--
+
+	// Was there a Token Available?
+	if b.tokens >= 0 { // YES: No Wait
+		return 0
+	}
+
+	// Is the Bucket Ever Refilled?
+	if b.rate <= 0 { // NO: Wait Forever
+		return time.Duration(1<<63 - 1)
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel Return a Reserved Token
+func (b *bucket) cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
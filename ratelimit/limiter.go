@@ -0,0 +1,241 @@
+package ratelimit
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Error when a Message would have to Wait Longer than Allowed
+var ErrRateLimited = errors.New("Rate Limit Exceeded")
+
+// Limit Hits (Published through expvar as "ratelimit")
+var hits = expvar.NewMap("ratelimit")
+
+// Outbound Send Rate Limiter
+type Limiter struct {
+	lock    sync.Mutex
+	global  *bucket                      // Global Limit
+	relay   *bucket                      // SMTP Relay Limit
+	domains map[string]*bucket           // Per Recipient Domain Limits
+	limits  map[string]*config.RateLimit // Per Recipient Domain Settings
+	maxWait time.Duration                // Longest Wait before Deferring
+}
+
+// NewLimiter Create Limiter from Configuration (Limiter without Limits Never Waits)
+func NewLimiter(c *config.DaemonConfig) *Limiter {
+	l := &Limiter{}
+	l.Update(c)
+	return l
+}
+
+// Update Apply Limits from (Reloaded) Configuration
+//
+// Buckets whose limits did not change are kept, so that a reload does not
+// hand out a fresh burst.
+func (l *Limiter) Update(c *config.DaemonConfig) {
+	if l == nil {
+		return
+	}
+
+	var global, relay *config.RateLimit
+	limits := map[string]*config.RateLimit{}
+	maxWait := 60 * time.Second
+
+	if c.SMTPRelay != nil {
+		relay = c.SMTPRelay.RateLimit
+	}
+
+	if c.RateLimits != nil {
+		global = c.RateLimits.Global
+
+		// NOTE: Domains are Case Insensitive
+		for d, r := range c.RateLimits.Domains {
+			limits[strings.ToLower(d)] = r
+		}
+
+		maxWait = time.Duration(c.RateLimits.MaxWait) * time.Second
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.global = keep(l.global, global)
+	l.relay = keep(l.relay, relay)
+	l.limits = limits
+	l.maxWait = maxWait
+
+	// Domain Buckets are Recreated on Demand if their Limit Changed
+	domains := map[string]*bucket{}
+	for d, b := range l.domains {
+		if b = keep(b, l.limit(d)); b != nil {
+			domains[d] = b
+		}
+	}
+	l.domains = domains
+}
+
+// keep Current Bucket if Limit is Unchanged, Otherwise New Bucket (nil if no Limit)
+func keep(b *bucket, r *config.RateLimit) *bucket {
+	// Is there a Limit?
+	if r == nil { // NO
+		return nil
+	}
+
+	// Is the Limit Unchanged?
+	if (b != nil) && b.limits(r.Rate, r.Burst) { // YES
+		return b
+	}
+
+	return newBucket(r.Rate, r.Burst)
+}
+
+// limit Settings for Domain ("*" Applies to Each Domain not Listed) (Lock Held)
+func (l *Limiter) limit(domain string) *config.RateLimit {
+	if r, ok := l.limits[domain]; ok {
+		return r
+	}
+	return l.limits["*"]
+}
+
+func (l *Limiter) domainBucket(domain string) *bucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Do we Already have a Bucket for the Domain?
+	b, ok := l.domains[domain]
+	if ok { // YES
+		return b
+	}
+
+	// Is the Domain Limited?
+	r := l.limit(domain)
+	if r == nil { // NO
+		return nil
+	}
+
+	b = newBucket(r.Rate, r.Burst)
+	l.domains[domain] = b
+	return b
+}
+
+// Domains List of Unique Recipient Domains in Address Lists
+func Domains(lists ...string) []string {
+	var domains []string
+	seen := map[string]bool{}
+
+	for _, list := range lists {
+		for _, address := range strings.FieldsFunc(list, func(r rune) bool { return r == ';' || r == ',' }) {
+			address = strings.TrimSpace(strings.TrimRight(address, ">"))
+			i := strings.LastIndex(address, "@")
+			if i < 0 {
+				continue
+			}
+
+			d := strings.ToLower(address[i+1:])
+			if (d != "") && !seen[d] {
+				seen[d] = true
+				domains = append(domains, d)
+			}
+		}
+	}
+
+	return domains
+}
+
+// Wait Block until Message can be Sent to Domains
+//
+// If the wait would exceed the configured maximum, nothing is consumed and
+// ErrRateLimited is returned, so the message can be deferred. If the context is
+// cancelled while waiting, its error is returned.
+func (l *Limiter) Wait(ctx context.Context, domains []string) error {
+	// Is Limiter Enabled?
+	if l == nil { // NO
+		return nil
+	}
+
+	// Applicable Buckets
+	type limit struct {
+		name string
+		b    *bucket
+	}
+	var limits []limit
+
+	// Limits can Change on Reload
+	l.lock.Lock()
+	if l.global != nil {
+		limits = append(limits, limit{"global", l.global})
+	}
+
+	if l.relay != nil {
+		limits = append(limits, limit{"relay", l.relay})
+	}
+	maxWait := l.maxWait
+	l.lock.Unlock()
+
+	for _, d := range domains {
+		if b := l.domainBucket(d); b != nil {
+			limits = append(limits, limit{"domain:" + d, b})
+		}
+	}
+
+	// Reserve a Token in Each Bucket
+	var wait time.Duration
+	now := time.Now()
+	hit := ""
+	for _, r := range limits {
+		w := r.b.reserve(now)
+		if w > wait {
+			wait = w
+			hit = r.name
+		}
+	}
+
+	// Do we Need to Wait?
+	if wait <= 0 { // NO
+		return nil
+	}
+
+	hits.Add(hit, 1)
+
+	// Would we Wait too Long?
+	if wait > maxWait { // YES: Give Back Tokens and Defer
+		for _, r := range limits {
+			r.b.cancel()
+		}
+
+		log.Printf("Rate Limit [%s] Hit. Deferring Message...", hit)
+		hits.Add("deferred", 1)
+		return ErrRateLimited
+	}
+
+	log.Printf("Rate Limit [%s] Hit. Waiting [%s]...", hit, wait.Round(time.Millisecond))
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		for _, r := range limits {
+			r.b.cancel()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// limits Configuration with Rate Limits
+func limits(global *config.RateLimit, domains map[string]*config.RateLimit, maxWait int) *config.DaemonConfig {
+	return &config.DaemonConfig{
+		SMTPRelay:  &config.SMTPRelay{},
+		RateLimits: &config.RateLimits{Global: global, Domains: domains, MaxWait: maxWait},
+	}
+}
+
+// counter Current Value of Hit Counter
+func counter(name string) int64 {
+	if v, ok := expvar.Get("ratelimit").(*expvar.Map).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestDomains(t *testing.T) {
+	got := Domains("Ana <ana@Example.com>; bob@example.com", "carol@other.org, bad-address", "")
+	if want := []string{"example.com", "other.org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Domains %v Expected %v", got, want)
+	}
+}
+
+func TestNoLimits(t *testing.T) {
+	l := NewLimiter(&config.DaemonConfig{SMTPRelay: &config.SMTPRelay{}})
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(context.Background(), []string{"example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Disabled Limiter
+	var disabled *Limiter
+	if err := disabled.Wait(context.Background(), nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBurstThenDefer(t *testing.T) {
+	// 1 Message per Minute after a Burst of 2, Never Wait
+	l := NewLimiter(limits(&config.RateLimit{Rate: 1, Burst: 2}, nil, 0))
+	deferred := counter("deferred")
+
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(context.Background(), nil); err != nil {
+			t.Fatalf("Message [%d] Limited [%s]", i, err)
+		}
+	}
+
+	if err := l.Wait(context.Background(), nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Error [%v] Expected [%s]", err, ErrRateLimited)
+	}
+
+	if counter("deferred") != deferred+1 {
+		t.Error("Deferral not Counted")
+	}
+
+	// Deferral Gives Back the Token: Still Limited, not Further Behind
+	if w := l.global.reserve(time.Now()); w > time.Minute {
+		t.Errorf("Deferred Message Consumed Token (Wait [%s])", w)
+	}
+}
+
+func TestDomainLimits(t *testing.T) {
+	l := NewLimiter(limits(nil, map[string]*config.RateLimit{
+		"Example.com": {Rate: 1},
+		"*":           {Rate: 1, Burst: 2},
+	}, 0))
+
+	ctx := context.Background()
+	if err := l.Wait(ctx, []string{"example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Wait(ctx, []string{"example.com"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Listed Domain not Limited [%v]", err)
+	}
+
+	// Other Domains have Buckets of their Own
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(ctx, []string{"other.org"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Wait(ctx, []string{"other.org"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Wildcard Domain not Limited [%v]", err)
+	}
+	if counter("domain:other.org") == 0 {
+		t.Error("Domain Hit not Counted")
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	l := NewLimiter(limits(&config.RateLimit{Rate: 1}, nil, 3600))
+	l.Wait(context.Background(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Error [%v] Expected [%s]", err, context.DeadlineExceeded)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(&config.DaemonConfig{SMTPRelay: &config.SMTPRelay{}})
+
+	// Limits Added on Reload
+	c := limits(&config.RateLimit{Rate: 1}, map[string]*config.RateLimit{"example.com": {Rate: 1}}, 0)
+	l.Update(c)
+	if err := l.Wait(ctx, []string{"example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Wait(ctx, nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Added Limit not Applied [%v]", err)
+	}
+
+	// Unchanged Limits Keep their State (No Fresh Burst)
+	l.Update(limits(&config.RateLimit{Rate: 1}, map[string]*config.RateLimit{"example.com": {Rate: 1}}, 0))
+	if err := l.Wait(ctx, nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Reload Reset Unchanged Limit [%v]", err)
+	}
+
+	// Changed Limits Apply
+	l.Update(limits(&config.RateLimit{Rate: 1, Burst: 5}, map[string]*config.RateLimit{"example.com": {Rate: 1}}, 0))
+	if err := l.Wait(ctx, nil); err != nil {
+		t.Fatalf("Changed Limit not Applied [%s]", err)
+	}
+	if err := l.Wait(ctx, []string{"example.com"}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Reload Reset Unchanged Domain Limit [%v]", err)
+	}
+
+	// Limits Removed on Reload
+	l.Update(&config.DaemonConfig{SMTPRelay: &config.SMTPRelay{}})
+	if err := l.Wait(ctx, []string{"example.com"}); err != nil {
+		t.Errorf("Removed Limit Applied [%s]", err)
+	}
+}
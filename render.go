@@ -0,0 +1,183 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/objectvault/queue-interface/messages"
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/mailer"
+	"github.com/objectvault/queue-smtp-mailer/poller"
+)
+
+// loadRequest Email Request from Request File, or Built from Template, Locale and Parameters File
+func loadRequest(request string, template string, locale string, params string, to string) (*messages.EmailMessage, error) {
+	// Full Request?
+	if request != "" { // YES
+		b, err := os.ReadFile(request)
+		if err != nil {
+			return nil, err
+		}
+		return poller.ParseRequest(b)
+	}
+
+	if template == "" {
+		return nil, errors.New("Template or Request Required")
+	}
+
+	source := map[string]interface{}{
+		"template": template,
+		"to":       to,
+		"params":   map[string]interface{}{},
+	}
+
+	if locale != "" {
+		source["locale"] = locale
+	}
+
+	if params != "" {
+		b, err := os.ReadFile(params)
+		if err != nil {
+			return nil, err
+		}
+
+		p := map[string]interface{}{}
+		err = json.Unmarshal(b, &p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Parameters File [%s]", err)
+		}
+		source["params"] = p
+	}
+
+	b, _ := json.Marshal(source)
+	return poller.ParseRequest(b)
+}
+
+// renderCommand Preview Template Output (Returns Exit Code)
+func renderCommand(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Preview Template Output
+
+		Usage:
+		  server render [-c /path/to/conf] -t <template> [-l <locale>] [-p params.json] [-part mime|text|html] [-o file]
+		  server render [-c /path/to/conf] -r request.json [-part mime|text|html] [-o file]
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    -t            Template name.
+		    -l            Locale [default: none, base template].
+		    -p            JSON file with template parameters.
+		    -to           Destination address [default: preview@example.com].
+		    -r            JSON file with a full email request (or queue message).
+		    -part         Output: full MIME message, text or html part [default: mime].
+		    -o            Output file [default: stdout].
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	sTemplate := flags.String("t", "", "Template name")
+	sLocale := flags.String("l", "", "Locale")
+	sParams := flags.String("p", "", "Parameters file")
+	sTo := flags.String("to", "preview@example.com", "Destination address")
+	sRequest := flags.String("r", "", "Request file")
+	sPart := flags.String("part", "mime", "Part to output")
+	sOutput := flags.String("o", "", "Output file")
+	flags.Parse(args)
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	msg, err := loadRequest(*sRequest, *sTemplate, *sLocale, *sParams, *sTo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Parameters Valid for Template?
+	err = mailer.ValidateParameters(c, msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Render Requested Part
+	var output []byte
+	switch *sPart {
+	case "mime":
+		email, err := mailer.BuildMail(c, msg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		buf, err := email.MimeBuf()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		output = buf.Bytes()
+	case "text", "html":
+		rendered, err := mailer.Render(c, msg.Template(), msg.Language(), mailer.TemplateParameters(msg))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if *sPart == "text" {
+			if !rendered.HasText {
+				fmt.Fprintln(os.Stderr, "Template has no Text Part")
+				return 1
+			}
+			output = []byte(rendered.Text)
+		} else {
+			if !rendered.HasHTML {
+				fmt.Fprintln(os.Stderr, "Template has no HTML Part")
+				return 1
+			}
+			output = []byte(rendered.HTML)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	// Write Output
+	var w io.Writer = os.Stdout
+	if *sOutput != "" {
+		f, err := os.Create(*sOutput)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err = w.Write(output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
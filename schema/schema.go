@@ -0,0 +1,276 @@
+package schema
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Subset of JSON Schema (draft 7) Sufficient to Describe Template Parameters:
+// type, enum, required, properties, additionalProperties, items, minItems,
+// maxItems, minLength, maxLength, pattern, minimum and maximum.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type Schema struct {
+	Type                 types              `json:"type,omitempty"`                 // Allowed Types
+	Enum                 []interface{}      `json:"enum,omitempty"`                 // Allowed Values
+	Required             []string           `json:"required,omitempty"`             // Required Properties (object)
+	Properties           map[string]*Schema `json:"properties,omitempty"`           // Property Schemas (object)
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"` // Schema for Other Properties (object)
+	Items                *Schema            `json:"items,omitempty"`                // Element Schema (array)
+	MinItems             *int               `json:"minItems,omitempty"`             // (array)
+	MaxItems             *int               `json:"maxItems,omitempty"`             // (array)
+	MinLength            *int               `json:"minLength,omitempty"`            // (string)
+	MaxLength            *int               `json:"maxLength,omitempty"`            // (string)
+	Pattern              string             `json:"pattern,omitempty"`              // Regular Expression (string)
+	Minimum              *float64           `json:"minimum,omitempty"`              // (number)
+	Maximum              *float64           `json:"maximum,omitempty"`              // (number)
+
+	never   bool           // Schema is 'false' (Nothing Valid)
+	pattern *regexp.Regexp // Compiled Pattern
+}
+
+// types JSON Schema 'type' (Single Type or List of Types)
+type types []string
+
+func (t *types) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*t = types{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return errors.New("'type' must be a string or list of strings")
+	}
+
+	*t = list
+	return nil
+}
+
+// UnmarshalJSON Accepts Boolean Schemas (true: Anything, false: Nothing)
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var boolean bool
+	if json.Unmarshal(b, &boolean) == nil {
+		*s = Schema{never: !boolean}
+		return nil
+	}
+
+	// Avoid Recursion into this Method
+	type plain Schema
+	var p plain
+	err := json.Unmarshal(b, &p)
+	if err != nil {
+		return err
+	}
+
+	*s = Schema(p)
+
+	if s.Pattern != "" {
+		s.pattern, err = regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid 'pattern' [%s]", s.Pattern)
+		}
+	}
+	return nil
+}
+
+// Load Schema from File
+func Load(path string) (*Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schema{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Schema [%s]: %s", path, err)
+	}
+
+	return s, nil
+}
+
+// FoldNames Lower Case Names of Properties and Required Properties
+//
+// Only the top level object is changed, for values whose keys are lower cased
+// before validation (i.e. request parameters).
+func (s *Schema) FoldNames() {
+	for i, r := range s.Required {
+		s.Required[i] = strings.ToLower(r)
+	}
+
+	if s.Properties == nil {
+		return
+	}
+
+	// Sorted so that the Same Property wins if Names Collide
+	names := make([]string, 0, len(s.Properties))
+	for k := range s.Properties {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	folded := make(map[string]*Schema, len(s.Properties))
+	for _, k := range names {
+		folded[strings.ToLower(k)] = s.Properties[k]
+	}
+	s.Properties = folded
+}
+
+// Validation Failures (One per Invalid Value)
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Validate Value (Decoded JSON) Against Schema, path Names the Value in Errors
+func (s *Schema) Validate(path string, v interface{}) error {
+	var errs Errors
+	s.validate(path, v, &errs)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func typeOf(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+		return "number"
+	case int, int64:
+		return "integer"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *Schema) validate(path string, v interface{}, errs *Errors) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.never {
+		fail("not allowed")
+		return
+	}
+
+	// Type Check
+	actual := typeOf(v)
+	if len(s.Type) > 0 {
+		ok := false
+		for _, t := range s.Type {
+			// Integers are also Numbers
+			if (t == actual) || ((t == "number") && (actual == "integer")) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			fail("expected %s, got %s", strings.Join(s.Type, " or "), actual)
+			return
+		}
+	}
+
+	// Enumerated Values
+	if len(s.Enum) > 0 {
+		ok := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) && typeOf(e) == actual {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			fail("value not allowed [%v]", v)
+		}
+	}
+
+	switch x := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(x)
+		if (s.MinLength != nil) && (n < *s.MinLength) {
+			fail("shorter than %d characters", *s.MinLength)
+		}
+		if (s.MaxLength != nil) && (n > *s.MaxLength) {
+			fail("longer than %d characters", *s.MaxLength)
+		}
+		if (s.pattern != nil) && !s.pattern.MatchString(x) {
+			fail("does not match pattern [%s]", s.Pattern)
+		}
+	case float64:
+		if (s.Minimum != nil) && (x < *s.Minimum) {
+			fail("less than %v", *s.Minimum)
+		}
+		if (s.Maximum != nil) && (x > *s.Maximum) {
+			fail("greater than %v", *s.Maximum)
+		}
+	case []interface{}:
+		if (s.MinItems != nil) && (len(x) < *s.MinItems) {
+			fail("fewer than %d items", *s.MinItems)
+		}
+		if (s.MaxItems != nil) && (len(x) > *s.MaxItems) {
+			fail("more than %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, e := range x {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, r := range s.Required {
+			if _, ok := x[r]; !ok {
+				*errs = append(*errs, path+"."+r+": required")
+			}
+		}
+
+		// Sorted for Stable Error Messages
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if p, ok := s.Properties[k]; ok {
+				p.validate(path+"."+k, x[k], errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(path+"."+k, x[k], errs)
+			}
+		}
+	}
+}
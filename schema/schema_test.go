@@ -0,0 +1,74 @@
+package schema
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFoldNames(t *testing.T) {
+	s := &Schema{}
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["orderId", "Total"],
+		"properties": {
+			"orderId": {"type": "string"},
+			"Total":   {"type": "number"},
+			"Note":    {"type": ["string", "null"]}
+		},
+		"additionalProperties": false
+	}`), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.FoldNames()
+
+	tests := []struct {
+		name   string
+		params string
+		errors []string
+	}{
+		{"valid", `{"orderid": "A1", "total": 10}`, nil},
+		{"null allowed", `{"orderid": "A1", "total": 10, "note": null}`, nil},
+		{"missing", `{"total": 10}`, []string{"params.orderid: required"}},
+		{"wrong type", `{"orderid": 1, "total": 10}`, []string{"params.orderid: expected string"}},
+		{"null not allowed", `{"orderid": null, "total": 10}`, []string{"params.orderid: expected string, got null"}},
+		{"unknown", `{"orderid": "A1", "total": 10, "other": 1}`, []string{"params.other: not allowed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(tt.params), &params); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.Validate("params", params)
+			if tt.errors == nil {
+				if err != nil {
+					t.Fatalf("Unexpected Error [%s]", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Invalid Parameters Accepted")
+			}
+			for _, want := range tt.errors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Error [%s] Expected [%s]", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/mailer"
+)
+
+// sendCommand Send a Single Request without the Queue (Returns Exit Code)
+func sendCommand(args []string) int {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Send a Single Email Request (Bypassing the Queue)
+
+		Usage:
+		  server send [-c /path/to/conf] --request request.json [--dry-run]
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    --request     JSON file with a full email request (or queue message).
+		    --dry-run     Render and print the SMTP envelope and message, but don't send.
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	sRequest := flags.String("request", "", "Request file")
+	bDryRun := flags.Bool("dry-run", false, "Don't send")
+	flags.Parse(args)
+
+	// Do we have a Request?
+	if *sRequest == "" { // NO
+		flags.Usage()
+		return 2
+	}
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Same Path as Queue Messages
+	msg, err := loadRequest(*sRequest, "", "", "", "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = mailer.ValidateParameters(c, msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Rendered and Sent as by the Daemon
+	mime, err := mailer.RenderMIME(c, msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Dry Run?
+	if *bDryRun { // YES: Show what Would be Sent
+		from, rcpt := mailer.Envelope(msg)
+		fmt.Printf("RELAY: %s\n", mailer.Relay(c))
+		fmt.Printf("MAIL FROM:<%s>\n", from)
+		for _, to := range rcpt {
+			fmt.Printf("RCPT TO:<%s>\n", to)
+		}
+		fmt.Println("DATA")
+		fmt.Println(strings.TrimRight(string(mime), "\r\n"))
+		fmt.Println(".")
+		return 0
+	}
+
+	reply, err := mailer.SendMIME(c, msg, mime)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED via [%s] %s\n", mailer.Relay(c), err)
+		return 1
+	}
+
+	fmt.Printf("SENT via [%s] [%s]\n", mailer.Relay(c), reply)
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/objectvault/queue-smtp-mailer/internal/harness"
+)
+
+// stdout Run Function Capturing Standard Output
+func stdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestSendCommand(t *testing.T) {
+	server, err := harness.NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Respond("DATA", 250, "2.0.0 Ok: queued as 4F2A")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"templates/welcome.text.template": "Hello {{.name}}",
+		"mailer.json": fmt.Sprintf(`{
+			"source": {"type": "directory", "path": %q},
+			"relay": {"server": {"host": %q, "port": %d}},
+			"paths": {"templates": %q},
+			"options": {}
+		}`, filepath.Join(dir, "inbox"), server.Host(), server.Port(), filepath.Join(dir, "templates")),
+		"request.json": `{"template": "welcome", "to": "John.Doe@Example.com", "params": {"name": "John"}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(dir, "inbox"), 0700)
+
+	args := []string{"-c", filepath.Join(dir, "mailer.json"), "--request", filepath.Join(dir, "request.json")}
+
+	// Dry Run: Nothing Sent
+	out := stdout(t, func() {
+		if code := sendCommand(append(args, "--dry-run")); code != 0 {
+			t.Fatalf("Dry Run Exit Code [%d]", code)
+		}
+	})
+	if !strings.Contains(out, "RCPT TO:<John.Doe@example.com>") || !strings.Contains(out, "Hello John") || (len(server.Messages()) != 0) {
+		t.Fatalf("Unexpected Dry Run:\n%s", out)
+	}
+
+	// Sent as by the Daemon, with the Relay's Reply
+	out = stdout(t, func() {
+		if code := sendCommand(args); code != 0 {
+			t.Fatalf("Exit Code [%d]", code)
+		}
+	})
+	if !strings.Contains(out, "[250 2.0.0 Ok: queued as 4F2A]") {
+		t.Errorf("Relay Reply not Printed:\n%s", out)
+	}
+
+	sent := server.Messages()
+	if (len(sent) != 1) || (sent[0].To[0] != "John.Doe@example.com") || !strings.Contains(sent[0].Data, "Hello John") {
+		t.Errorf("Unexpected Messages Sent %+v", sent)
+	}
+}
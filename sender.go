@@ -0,0 +1,167 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"log"
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-interface/queue"
+	"github.com/objectvault/queue-smtp-mailer/broker"
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Broker over a Connection of its Own, (Re)Opened on Demand
+//
+// Used by the spool senders to publish status events and dead letters, so that
+// spooled messages are sent whether or not the connector has a session open.
+// Declarations are remembered and repeated on every connect (so they succeed
+// while the queue server is unreachable). Failed operations drop the
+// connection, to be reopened by the next one.
+type dialBroker struct {
+	config   *config.DaemonConfig          // Queue Server Settings
+	lock     sync.Mutex                    // Serializes Operations
+	mq       *queue.AMQPServerConnection   // Open Connection (nil if Closed)
+	broker   *broker.AMQP                  // Broker over Open Connection
+	declares []func(b broker.Broker) error // Declarations Repeated on Connect
+}
+
+var _ broker.Broker = (*dialBroker)(nil)
+
+// newDialBroker Broker for Queue Servers in Configuration (Not Connected)
+func newDialBroker(c *config.DaemonConfig) *dialBroker {
+	return &dialBroker{config: c}
+}
+
+// connect Open Connection and Repeat Declarations
+func (d *dialBroker) connect() error {
+	mq, err := setMQConnection(d.config.Queue)
+	if err == nil {
+		err = connectAny(mq, d.config.Queue.Servers)
+	}
+	if err != nil {
+		return err
+	}
+
+	b := broker.NewAMQP(mq)
+	for _, declare := range d.declares {
+		err = declare(b)
+		if err != nil {
+			mq.CloseConnection()
+			return err
+		}
+	}
+
+	d.mq, d.broker = mq, b
+	return nil
+}
+
+// Close Connection (if Open)
+func (d *dialBroker) Close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.mq != nil {
+		d.mq.CloseConnection()
+		d.mq, d.broker = nil, nil
+	}
+}
+
+// do Run Operation on Open Connection (Connecting if Required)
+func (d *dialBroker) do(op func(b broker.Broker) error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Connection Open?
+	if d.broker == nil { // NO
+		if err := d.connect(); err != nil {
+			log.Printf("[sender] Connection Failed [%s]", err)
+			return err
+		}
+	}
+
+	err := op(d.broker)
+
+	// Operation Failed: Connection might be Stale, Reopen on Next
+	if err != nil {
+		d.mq.CloseConnection()
+		d.mq, d.broker = nil, nil
+	}
+	return err
+}
+
+// declare Remember Declaration (Made on Next Connect)
+func (d *dialBroker) declare(op func(b broker.Broker) error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.declares = append(d.declares, op)
+
+	// Connection Open?
+	if d.broker == nil { // NO: Declared on Connect
+		return nil
+	}
+	return op(d.broker)
+}
+
+// BROKER //
+
+func (d *dialBroker) QueueRetrieve(channel string, queue string) (*amqp.Delivery, error) {
+	var delivery *amqp.Delivery
+	err := d.do(func(b broker.Broker) error {
+		var err error
+		delivery, err = b.QueueRetrieve(channel, queue)
+		return err
+	})
+	return delivery, err
+}
+
+func (d *dialBroker) QueueDeclare(channel string, queue string) error {
+	return d.declare(func(b broker.Broker) error {
+		return b.QueueDeclare(channel, queue)
+	})
+}
+
+func (d *dialBroker) QueuePublish(channel string, queue string, msg amqp.Publishing) error {
+	return d.do(func(b broker.Broker) error {
+		return b.QueuePublish(channel, queue, msg)
+	})
+}
+
+func (d *dialBroker) ExchangeDeclare(channel string, exchange string) error {
+	return d.declare(func(b broker.Broker) error {
+		return b.ExchangeDeclare(channel, exchange)
+	})
+}
+
+func (d *dialBroker) QueueBind(channel string, queue string, key string, exchange string) error {
+	return d.declare(func(b broker.Broker) error {
+		return b.QueueBind(channel, queue, key, exchange)
+	})
+}
+
+func (d *dialBroker) Publish(channel string, exchange string, key string, msg amqp.Publishing) error {
+	return d.do(func(b broker.Broker) error {
+		return b.Publish(channel, exchange, key, msg)
+	})
+}
+
+func (d *dialBroker) NotifyClose(channel string, queue string) (<-chan *amqp.Error, error) {
+	var closed <-chan *amqp.Error
+	err := d.do(func(b broker.Broker) error {
+		var err error
+		closed, err = b.NotifyClose(channel, queue)
+		return err
+	})
+	return closed, err
+}
@@ -0,0 +1,112 @@
+package source
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-smtp-mailer/broker"
+)
+
+// AMQP Source Reading a Queue through a Broker
+type AMQP struct {
+	broker broker.Broker     // Queue Server
+	queue  string            // Queue Name
+	closed chan error        // Connection or Channel Closed
+	lock   sync.Mutex        // Protects acker
+	acker  amqp.Acknowledger // Channel Messages were Received on
+}
+
+// NewAMQP Source for Queue (Broker Connection has to be Open)
+func NewAMQP(b broker.Broker, queue string) (*AMQP, error) {
+	notify, err := b.NotifyClose("read", queue)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &AMQP{
+		broker: b,
+		queue:  queue,
+		closed: make(chan error, 1),
+	}
+
+	// Forward Close Notification
+	go func() {
+		e := <-notify
+		if e == nil {
+			s.closed <- amqp.ErrClosed
+			return
+		}
+		s.closed <- e
+	}()
+
+	return s, nil
+}
+
+func (s *AMQP) Name() string {
+	return "amqp:" + s.queue
+}
+
+func (s *AMQP) Fetch() (*amqp.Delivery, error) {
+	d, err := s.broker.QueueRetrieve("read", s.queue)
+	if (err != nil) || (d == nil) {
+		return nil, err
+	}
+
+	// Settle through Source
+	s.lock.Lock()
+	s.acker = d.Acknowledger
+	s.lock.Unlock()
+
+	d.Acknowledger = s
+	return d, nil
+}
+
+func (s *AMQP) Closed() <-chan error {
+	return s.closed
+}
+
+func (s *AMQP) acknowledger() (amqp.Acknowledger, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.acker == nil {
+		return nil, errors.New("[source.AMQP] No Message Fetched")
+	}
+	return s.acker, nil
+}
+
+func (s *AMQP) Ack(tag uint64, multiple bool) error {
+	a, err := s.acknowledger()
+	if err != nil {
+		return err
+	}
+	return a.Ack(tag, multiple)
+}
+
+func (s *AMQP) Nack(tag uint64, multiple bool, requeue bool) error {
+	a, err := s.acknowledger()
+	if err != nil {
+		return err
+	}
+	return a.Nack(tag, multiple, requeue)
+}
+
+func (s *AMQP) Reject(tag uint64, requeue bool) error {
+	a, err := s.acknowledger()
+	if err != nil {
+		return err
+	}
+	return a.Reject(tag, requeue)
+}
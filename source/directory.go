@@ -0,0 +1,229 @@
+package source
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-interface/messages"
+)
+
+// Request File Extensions
+const (
+	extRequest    = ".json"       // Request Waiting
+	extProcessing = ".processing" // Request Fetched (not yet Settled)
+)
+
+// Sub-Directory for Rejected Requests
+const rejectedDir = "rejected"
+
+// Directory Source Reading Request Files Dropped into a Folder
+//
+// Files are claimed by renaming them, so producers should write files under a
+// temporary name (or starting with '.') and rename them into place. Requests can
+// be bare email requests (the file name is the message ID) or queue messages.
+// Acknowledged files are removed, rejected files are moved to 'rejected/'.
+type Directory struct {
+	dir     string            // Request Directory
+	lock    sync.Mutex        // Protects tag and fetched
+	tag     uint64            // Last Delivery Tag
+	fetched map[uint64]string // Claimed File by Delivery Tag
+}
+
+// OpenDirectory Source for Directory, Recovering Requests Claimed by a Previous Run
+func OpenDirectory(dir string) (*Directory, error) {
+	err := os.MkdirAll(filepath.Join(dir, rejectedDir), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests being Processed when the Daemon Stopped are Processed Again
+	count := 0
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == extProcessing {
+			path := filepath.Join(dir, e.Name())
+			if os.Rename(path, strings.TrimSuffix(path, extProcessing)+extRequest) == nil {
+				count++
+			}
+		}
+	}
+	log.Printf("Directory Source [%s] Recovered [%d] Requests", dir, count)
+
+	return &Directory{
+		dir:     dir,
+		fetched: map[uint64]string{},
+	}, nil
+}
+
+func (s *Directory) Name() string {
+	return "directory:" + s.dir
+}
+
+// Fetch Claim Oldest Request File
+func (s *Directory) Fetch() (*amqp.Delivery, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Oldest First
+	type candidate struct {
+		name string
+		info os.FileInfo
+	}
+	var candidates []candidate
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || (filepath.Ext(e.Name()) != extRequest) {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil { // Claimed by Someone Else
+			continue
+		}
+		candidates = append(candidates, candidate{e.Name(), info})
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].info.ModTime().Before(candidates[b].info.ModTime())
+	})
+
+	for _, c := range candidates {
+		path := filepath.Join(s.dir, c.name)
+		claimed := strings.TrimSuffix(path, extRequest) + extProcessing
+
+		// Did we Claim the File?
+		if os.Rename(path, claimed) != nil { // NO: Claimed by Someone Else
+			continue
+		}
+
+		body, err := os.ReadFile(claimed)
+		if err != nil {
+			return nil, err
+		}
+
+		id := strings.TrimSuffix(c.name, extRequest)
+		body, err = toQueueMessage(id, body)
+		if err != nil { // Left for the Poller to Reject
+			log.Printf("Invalid Request File [%s] [%s]", c.name, err)
+		}
+
+		s.lock.Lock()
+		s.tag++
+		tag := s.tag
+		s.fetched[tag] = claimed
+		s.lock.Unlock()
+
+		return &amqp.Delivery{
+			Acknowledger: s,
+			DeliveryTag:  tag,
+			MessageId:    id,
+			ContentType:  "application/json",
+			Timestamp:    c.info.ModTime(),
+			Body:         body,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// toQueueMessage Wrap Bare Request as Queue Message
+func toQueueMessage(id string, body []byte) ([]byte, error) {
+	source := map[string]interface{}{}
+	err := json.Unmarshal(body, &source)
+	if err != nil {
+		return body, err
+	}
+
+	// Is it Already a Queue Message?
+	if _, ok := source["message"].(map[string]interface{}); ok && (source["id"] != nil) { // YES
+		return body, nil
+	}
+
+	qm := &messages.QueueMessage{}
+	qm.SetVersion(1)
+	qm.SetID(id)
+	qm.SetMessage(source)
+	return json.Marshal(qm)
+}
+
+// Submit Drop Request File into Directory (Written under a Hidden Name then Renamed)
+func (s *Directory) Submit(id string, body []byte) error {
+	tmp := filepath.Join(s.dir, "."+id+".tmp")
+	err := os.WriteFile(tmp, body, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(s.dir, id+extRequest))
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (s *Directory) Closed() <-chan error {
+	return nil
+}
+
+// settle Release Claimed File
+func (s *Directory) settle(tag uint64) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	path, ok := s.fetched[tag]
+	if !ok {
+		return "", fmt.Errorf("[source.Directory] Unknown Delivery Tag [%d]", tag)
+	}
+	delete(s.fetched, tag)
+	return path, nil
+}
+
+func (s *Directory) Ack(tag uint64, multiple bool) error {
+	path, err := s.settle(tag)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *Directory) Nack(tag uint64, multiple bool, requeue bool) error {
+	path, err := s.settle(tag)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), extProcessing) + extRequest
+
+	// Return to Directory?
+	if requeue { // YES
+		return os.Rename(path, filepath.Join(s.dir, name))
+	}
+
+	return os.Rename(path, filepath.Join(s.dir, rejectedDir, name))
+}
+
+func (s *Directory) Reject(tag uint64, requeue bool) error {
+	return s.Nack(tag, false, requeue)
+}
@@ -0,0 +1,123 @@
+package source
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-interface/messages"
+)
+
+func drop(t *testing.T, dir string, name string, body string, age time.Duration) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestDirectory(t *testing.T) {
+	dir := t.TempDir()
+	drop(t, dir, "newer.json", `{"id": "q1", "message": {"template": "welcome"}}`, time.Minute)
+	drop(t, dir, "older.json", `{"template": "welcome", "to": "user@example.com"}`, time.Hour)
+	drop(t, dir, ".partial.json", `{`, 2*time.Hour)
+	drop(t, dir, "notes.txt", `ignored`, 2*time.Hour)
+
+	s, err := OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Oldest First, Bare Request Wrapped with File Name as ID
+	d, err := s.Fetch()
+	if (err != nil) || (d == nil) {
+		t.Fatalf("Fetch Failed [%v]", err)
+	}
+
+	qm := messages.QueueMessage{}
+	if err := qm.UnmarshalJSON(d.Body); (err != nil) || (qm.ID() != "older") || !qm.IsValid() {
+		t.Fatalf("Request not Wrapped [%v] [%s]", err, d.Body)
+	}
+
+	if !exists(filepath.Join(dir, "older.processing")) {
+		t.Error("Fetched File not Claimed")
+	}
+
+	// Queue Messages are Passed as Is
+	d2, _ := s.Fetch()
+	if (d2 == nil) || (d2.MessageId != "newer") || (string(d2.Body) != `{"id": "q1", "message": {"template": "welcome"}}`) {
+		t.Fatalf("Unexpected Delivery %+v", d2)
+	}
+
+	// Nothing Else to Read
+	if d3, _ := s.Fetch(); d3 != nil {
+		t.Fatalf("Unexpected Delivery [%s]", d3.MessageId)
+	}
+
+	if err := d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(dir, "older.processing")) {
+		t.Error("Acknowledged File not Removed")
+	}
+
+	if err := d2.Reject(false); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(dir, "rejected", "newer.json")) {
+		t.Error("Rejected File not Moved")
+	}
+
+	if err := d2.Ack(false); err == nil {
+		t.Error("Delivery Settled Twice")
+	}
+}
+
+func TestDirectoryRequeueAndRecovery(t *testing.T) {
+	dir := t.TempDir()
+	drop(t, dir, "a.json", `{"template": "welcome"}`, 0)
+	drop(t, dir, "b.processing", `{"template": "welcome"}`, 0)
+
+	// Claimed by Previous Run: Recovered
+	s, err := OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(dir, "b.json")) {
+		t.Fatal("Claimed File not Recovered")
+	}
+
+	d, _ := s.Fetch()
+	if d == nil {
+		t.Fatal("Nothing Fetched")
+	}
+
+	if err := d.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(dir, d.MessageId+".json")) {
+		t.Error("Requeued File not Returned")
+	}
+}
@@ -0,0 +1,32 @@
+package source
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"github.com/streadway/amqp"
+)
+
+// Source of Email Requests
+//
+// Messages are returned as deliveries whose Acknowledger is the Source, so that
+// they are settled (by delivery tag) exactly as if they came from an AMQP queue.
+type Source interface {
+	amqp.Acknowledger // Ack, Nack and Reject Fetched Messages
+
+	// Name Source Description (for Logs)
+	Name() string
+
+	// Fetch Next Message (nil if No Message is Waiting)
+	Fetch() (*amqp.Delivery, error)
+
+	// Closed Signaled when the Source can no Longer be Read (nil if Never)
+	Closed() <-chan error
+}
@@ -0,0 +1,284 @@
+package spool
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Spool File Extensions
+const (
+	extItem = ".msg" // Spooled Message
+	extTemp = ".tmp" // Message being Written
+)
+
+// Outcome of Processing a Spooled Message
+type Outcome int
+
+const (
+	Pending  Outcome = iota // Not Settled (Retry with Backoff)
+	Done                    // Acknowledged or Rejected (Removed from Spool)
+	Requeued                // Returned for Later (Retry without Counting Attempt)
+)
+
+// Spooled Message
+type Item struct {
+	ID          string    `json:"id"`                     // Queue Message ID
+	ContentType string    `json:"content-type,omitempty"` // Original Content Type
+	Body        []byte    `json:"body"`                   // Original Message Body
+	Attempts    int       `json:"attempts"`               // Send Attempts so Far
+	Spooled     time.Time `json:"spooled"`                // Time Message was Spooled
+	Next        time.Time `json:"next"`                   // Time of Next Attempt
+
+	name    string  // Spool File Name
+	outcome Outcome // Result of Last Attempt
+}
+
+// Outcome Result of Last Attempt
+func (i *Item) Outcome() Outcome {
+	return i.outcome
+}
+
+// Local Durable Outbox (Directory of Message Files)
+type Spool struct {
+	dir  string        // Spool Directory
+	lock sync.Mutex    // Serializes File Changes
+	wake chan struct{} // Signaled when a Message is Spooled
+}
+
+// Open Spool Directory, Recovering Messages from Previous Runs (nil Spool if not Configured)
+func Open(c *config.Spool) (*Spool, error) {
+	// Is the Spool Enabled?
+	if c == nil { // NO
+		return nil, nil
+	}
+
+	err := os.MkdirAll(c.Path, 0700)
+	if err != nil {
+		log.Printf("[spool.Open] Failed to Create Spool [%s]", c.Path)
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Recover Spool: Partially Written Messages were never Acknowledged
+	count := 0
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case extTemp:
+			os.Remove(filepath.Join(c.Path, e.Name()))
+		case extItem:
+			count++
+		}
+	}
+	log.Printf("Spool [%s] Recovered [%d] Messages", c.Path, count)
+
+	return &Spool{
+		dir:  c.Path,
+		wake: make(chan struct{}, 1),
+	}, nil
+}
+
+// Wake Channel Signaled when a Message is Spooled
+func (s *Spool) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// write Durably (Re-)Write Item File
+func (s *Spool) write(i *Item) error {
+	b, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, "*"+extTemp)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(s.dir, i.name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	// Make Sure Rename is on Disk
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Put Durably Write Delivery to Spool
+//
+// Once Put returns without error, the delivery can be acknowledged.
+func (s *Spool) Put(d *amqp.Delivery) error {
+	return s.PutAt(d, time.Now())
+}
+
+// PutAt Durably Write Delivery to Spool, Due at Time
+func (s *Spool) PutAt(d *amqp.Delivery, at time.Time) error {
+	now := time.Now()
+	r := make([]byte, 4)
+	rand.Read(r)
+
+	i := &Item{
+		ID:          d.MessageId,
+		ContentType: d.ContentType,
+		Body:        d.Body,
+		Spooled:     now,
+		Next:        at,
+		name:        fmt.Sprintf("%019d-%s%s", now.UnixNano(), hex.EncodeToString(r), extItem),
+	}
+
+	s.lock.Lock()
+	err := s.write(i)
+	s.lock.Unlock()
+	if err != nil {
+		log.Printf("[spool.Put] Failed to Spool Message [%s]", d.MessageId)
+		return err
+	}
+
+	// Wake Sender (if not Already Signaled)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Due Messages Ready to be Sent (Oldest First), and Time the Next is Due
+func (s *Spool) Due(now time.Time) ([]*Item, time.Time, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var due []*Item
+	var next time.Time
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != extItem {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil { // Removed since Read
+			continue
+		}
+
+		i := &Item{name: e.Name()}
+		err = json.Unmarshal(b, i)
+		if err != nil {
+			log.Printf("[spool.Due] Invalid Spool File [%s] [%s]", e.Name(), err)
+			continue
+		}
+
+		if !i.Next.After(now) {
+			due = append(due, i)
+		} else if next.IsZero() || i.Next.Before(next) {
+			next = i.Next
+		}
+	}
+
+	// NOTE: File Names Start with Spool Time
+	sort.Slice(due, func(a, b int) bool { return due[a].name < due[b].name })
+	return due, next, nil
+}
+
+// Remove Message from Spool
+func (s *Spool) Remove(i *Item) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := os.Remove(filepath.Join(s.dir, i.name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Retry Reschedule Message (Counting an Attempt if Requested)
+func (s *Spool) Retry(i *Item, delay time.Duration, attempt bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if attempt {
+		i.Attempts++
+	}
+	i.Next = time.Now().Add(delay)
+	return s.write(i)
+}
+
+// Delivery Wrap Spooled Message as Delivery, Settled back to the Spool
+func (s *Spool) Delivery(i *Item) *amqp.Delivery {
+	i.outcome = Pending
+	return &amqp.Delivery{
+		Acknowledger: &acknowledger{spool: s, item: i},
+		DeliveryTag:  1,
+		MessageId:    i.ID,
+		ContentType:  i.ContentType,
+		Body:         i.Body,
+		Redelivered:  i.Attempts > 0,
+		Timestamp:    i.Spooled,
+	}
+}
+
+// acknowledger Settle Spooled Message as if it came from the Queue
+type acknowledger struct {
+	spool *Spool
+	item  *Item
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.item.outcome = Done
+	return a.spool.Remove(a.item)
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		a.item.outcome = Requeued
+		return nil
+	}
+
+	a.item.outcome = Done
+	return a.spool.Remove(a.item)
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
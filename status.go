@@ -0,0 +1,117 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/status"
+)
+
+// statusCommand Query Message Status Store (Returns Exit Code)
+func statusCommand(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Query Message Status
+
+		Usage:
+		  server status [-c /path/to/conf] <id>
+		  server status [-c /path/to/conf] [-to address] [-template name] [-stage s] [-since t] [-limit n]
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    -to           Only messages to address.
+		    -template     Only messages using template.
+		    -stage        Only messages whose latest stage is s (i.e. sent, deferred, failed).
+		    -since        Only messages updated after time (duration ago, i.e. 24h, or RFC3339).
+		    -limit        Maximum messages listed [default: 100].
+
+		  The store is locked while the daemon runs. Use the HTTP API
+		  (GET /v1/messages) to query a running daemon.
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	sTo := flags.String("to", "", "Recipient")
+	sTemplate := flags.String("template", "", "Template")
+	sStage := flags.String("stage", "", "Latest stage")
+	sSince := flags.String("since", "", "Only messages updated since")
+	iLimit := flags.Int("limit", 100, "Maximum messages listed")
+	flags.Parse(args)
+
+	since, err := parseSince(*sSince)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Is a Status Store Configured?
+	if c.Status == nil { // NO
+		fmt.Fprintln(os.Stderr, "ERROR: No Status Store in Configuration File")
+		return 1
+	}
+
+	store, err := status.OpenView(c.Status)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to Open Status Store [%s] [%s] (is the daemon running?)\n", c.Status.Path, err)
+		return 1
+	}
+	defer store.Close()
+
+	// Show Single Message?
+	if flags.NArg() > 0 { // YES
+		r, err := store.Get(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if r == nil {
+			fmt.Fprintf(os.Stderr, "Not Found [%s]\n", flags.Arg(0))
+			return 1
+		}
+
+		b, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+
+	l, err := store.Find(&status.Query{
+		To:       *sTo,
+		Template: *sTemplate,
+		Stage:    status.Stage(*sStage),
+		Since:    since,
+		Limit:    *iLimit,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, r := range l {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Updated.Format(time.RFC3339), r.Stage, r.Template, r.To, r.Response)
+	}
+	fmt.Printf("Listed [%d]\n", len(l))
+	return 0
+}
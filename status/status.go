@@ -0,0 +1,300 @@
+package status
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/events"
+)
+
+// Bucket for Message Records (by Message ID)
+var bucketMessages = []byte("messages")
+
+// Maximum Transitions Kept per Message (Oldest Dropped First)
+const maxHistory = 50
+
+// Lifecycle Stage (Besides Stages Below, the Delivery Status of Events)
+type Stage string
+
+const (
+	Received  Stage = "received"  // Message Read from Source
+	Scheduled Stage = "scheduled" // Held until 'send_at'
+	Rendered  Stage = "rendered"  // Templates Expanded, About to Send
+)
+
+// Transition Stage Reached by Message
+type Transition struct {
+	Stage    Stage     `json:"stage"`                   // Stage Reached
+	At       time.Time `json:"at"`                      // When
+	Response string    `json:"smtp-response,omitempty"` // SMTP Server Reply
+	Error    string    `json:"error,omitempty"`         // Error Message
+}
+
+// Message Lifecycle Record
+type Record struct {
+	ID       string       `json:"id"`                      // Queue Message ID
+	Stage    Stage        `json:"stage"`                   // Latest Stage
+	Template string       `json:"template,omitempty"`      // Email Template
+	To       string       `json:"to,omitempty"`            // Email Destination
+	Relay    string       `json:"relay,omitempty"`         // SMTP Relay Used (host:port)
+	Response string       `json:"smtp-response,omitempty"` // Latest SMTP Server Reply
+	Created  string       `json:"created,omitempty"`       // Queue Message Creation TimeStamp
+	First    time.Time    `json:"first"`                   // First Seen
+	Updated  time.Time    `json:"updated"`                 // Latest Transition
+	History  []Transition `json:"history"`                 // Transitions (Oldest First)
+}
+
+// Query Record Filters (Empty Fields Match Everything)
+type Query struct {
+	To       string    // Recipient (Case Insensitive)
+	Template string    // Email Template
+	Stage    Stage     // Latest Stage
+	Since    time.Time // Updated at or After
+	Limit    int       // Maximum Records (0 No Limit)
+}
+
+// match Does Record Pass Query Filters?
+func (q *Query) match(r *Record) bool {
+	if (q.To != "") && !strings.EqualFold(q.To, r.To) {
+		return false
+	}
+
+	if (q.Template != "") && (q.Template != r.Template) {
+		return false
+	}
+
+	if (q.Stage != "") && (q.Stage != r.Stage) {
+		return false
+	}
+
+	return q.Since.IsZero() || !r.Updated.Before(q.Since)
+}
+
+// Status Store (Lifecycle of Processed Messages)
+type Store struct {
+	db        *bolt.DB      // Embedded Database
+	retention time.Duration // Time to Keep Records after Last Update
+}
+
+// Open Status Store (nil Store if Status Store is not Configured)
+func Open(c *config.Status) (*Store, error) {
+	// Is the Status Store Enabled?
+	if c == nil { // NO
+		return nil, nil
+	}
+
+	db, err := bolt.Open(c.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Printf("[status.Open] Failed to Open Store [%s]", c.Path)
+		return nil, err
+	}
+
+	// Make Sure Bucket Exists
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketMessages)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Store{
+		db:        db,
+		retention: time.Duration(c.Retention) * time.Second,
+	}
+
+	// Clear Expired Records from Previous Runs
+	// NOTE: Failure to Prune is not Fatal (Retried Hourly)
+	count, err := s.Prune()
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Status Store [%s] Pruned [%d] Records", c.Path, count)
+	}
+
+	return s, nil
+}
+
+// OpenView Open Status Store Read Only (Fails if the Daemon has it Open)
+func OpenView(c *config.Status) (*Store, error) {
+	db, err := bolt.Open(c.Path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close Store
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
+
+// Track Record Stage Reached by Message (Event Supplies Message Details)
+func (s *Store) Track(e *events.Event, stage Stage) error {
+	// Is the Status Store Enabled?
+	if (s == nil) || (e.ID == "") { // NO: Nothing to Record
+		return nil
+	}
+
+	now := time.Now().UTC()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMessages)
+
+		// Existing Record?
+		r := &Record{}
+		if v := b.Get([]byte(e.ID)); (v == nil) || (json.Unmarshal(v, r) != nil) { // NO: Start One
+			r = &Record{ID: e.ID, First: now}
+		}
+
+		// Latest Details Win
+		r.Stage = stage
+		r.Updated = now
+		if e.Template != "" {
+			r.Template = e.Template
+		}
+		if e.To != "" {
+			r.To = e.To
+		}
+		if e.Relay != "" {
+			r.Relay = e.Relay
+		}
+		if e.Response != "" {
+			r.Response = e.Response
+		}
+		if e.Created != "" {
+			r.Created = e.Created
+		}
+
+		r.History = append(r.History, Transition{
+			Stage:    stage,
+			At:       now,
+			Response: e.Response,
+			Error:    e.Error,
+		})
+		if len(r.History) > maxHistory {
+			r.History = r.History[len(r.History)-maxHistory:]
+		}
+
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(e.ID), v)
+	})
+}
+
+// Get Record for Message ID (nil if Unknown)
+func (s *Store) Get(id string) (*Record, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	var r *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketMessages).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		r = &Record{}
+		return json.Unmarshal(v, r)
+	})
+
+	return r, err
+}
+
+// Find Records Matching Query (Most Recently Updated First)
+func (s *Store) Find(q *Query) ([]*Record, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	var l []*Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMessages).ForEach(func(k, v []byte) error {
+			r := &Record{}
+			if json.Unmarshal(v, r) != nil { // Skip Invalid Records
+				return nil
+			}
+
+			if q.match(r) {
+				l = append(l, r)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(l, func(a, b int) bool {
+		return l[a].Updated.After(l[b].Updated)
+	})
+
+	if (q.Limit > 0) && (len(l) > q.Limit) {
+		l = l[:q.Limit]
+	}
+	return l, nil
+}
+
+// Prune Remove Records not Updated within Retention Period
+func (s *Store) Prune() (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	count := 0
+	cutoff := time.Now().Add(-s.retention)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMessages)
+
+		// Collect Expired or Invalid Records (Deleting while Iterating Skips Keys)
+		var expired [][]byte
+		b.ForEach(func(k, v []byte) error {
+			r := &Record{}
+			if (json.Unmarshal(v, r) != nil) || r.Updated.Before(cutoff) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+
+		for _, k := range expired {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		count = len(expired)
+		return nil
+	})
+
+	if err != nil {
+		return 0, errors.New("[status.Prune] " + err.Error())
+	}
+
+	return count, nil
+}
@@ -0,0 +1,96 @@
+package status
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/events"
+)
+
+func TestTrack(t *testing.T) {
+	s, err := Open(&config.Status{Path: filepath.Join(t.TempDir(), "status.db"), Retention: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	e := events.NewEvent("m1")
+	e.Template, e.To = "welcome", "user@example.com"
+	s.Track(e, Received)
+	s.Track(e, Rendered)
+	e.Response = "451 try later"
+	s.Track(e.Complete(events.StatusDeferred, nil), Stage(e.Status))
+
+	r, err := s.Get("m1")
+	if (err != nil) || (r == nil) {
+		t.Fatalf("Record not Found [%v]", err)
+	}
+
+	if (r.Stage != "deferred") || (r.Response != "451 try later") || (r.To != "user@example.com") {
+		t.Errorf("Unexpected Record %+v", r)
+	}
+
+	if (len(r.History) != 3) || (r.History[0].Stage != Received) || (r.History[2].Response != "451 try later") {
+		t.Errorf("Unexpected History %+v", r.History)
+	}
+
+	// Unknown Messages
+	if r, _ := s.Get("m2"); r != nil {
+		t.Errorf("Unexpected Record %+v", r)
+	}
+}
+
+func TestFindAndPrune(t *testing.T) {
+	s, err := Open(&config.Status{Path: filepath.Join(t.TempDir(), "status.db"), Retention: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		e := events.NewEvent(id)
+		e.Template, e.To = "welcome", id+"@example.com"
+		if id == "c" {
+			e.Template = "digest"
+		}
+		s.Track(e, Received)
+	}
+
+	if l, _ := s.Find(&Query{Template: "welcome"}); len(l) != 2 {
+		t.Errorf("Expected 2 Records, Got %d", len(l))
+	}
+
+	if l, _ := s.Find(&Query{To: "B@EXAMPLE.COM"}); (len(l) != 1) || (l[0].ID != "b") {
+		t.Errorf("Unexpected Records %v", l)
+	}
+
+	if l, _ := s.Find(&Query{Limit: 1}); (len(l) != 1) || (l[0].ID != "c") {
+		t.Errorf("Most Recent not First %v", l)
+	}
+
+	if l, _ := s.Find(&Query{Since: time.Now().Add(time.Minute)}); len(l) != 0 {
+		t.Errorf("Unexpected Records %v", l)
+	}
+
+	// Nothing Expired Yet
+	if n, _ := s.Prune(); n != 0 {
+		t.Errorf("Pruned %d Records", n)
+	}
+
+	s.retention = -time.Minute
+	if n, _ := s.Prune(); n != 3 {
+		t.Errorf("Expected 3 Records Pruned, Got %d", n)
+	}
+}
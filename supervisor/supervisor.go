@@ -0,0 +1,90 @@
+package supervisor
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Goroutine Supervisor: Tracks Goroutines so their Termination can be Awaited
+type Supervisor struct {
+	name    string         // Supervisor Name (for Logs)
+	wg      sync.WaitGroup // Running Goroutines
+	lock    sync.Mutex
+	running map[string]int // Running Goroutines by Name
+}
+
+// New Create Supervisor
+func New(name string) *Supervisor {
+	return &Supervisor{
+		name:    name,
+		running: map[string]int{},
+	}
+}
+
+// Go Run Function in a Supervised Goroutine (Panics are Logged, not Fatal)
+func (s *Supervisor) Go(name string, f func()) {
+	s.lock.Lock()
+	s.running[name]++
+	s.lock.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[%s] PANIC in [%s]: %v\n%s", s.name, name, r, debug.Stack())
+			}
+
+			s.lock.Lock()
+			s.running[name]--
+			if s.running[name] == 0 {
+				delete(s.running, name)
+			}
+			s.lock.Unlock()
+
+			s.wg.Done()
+		}()
+
+		f()
+	}()
+}
+
+// Running Number of Running Goroutines by Name
+func (s *Supervisor) Running() map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	running := make(map[string]int, len(s.running))
+	for k, v := range s.running {
+		running[k] = v
+	}
+	return running
+}
+
+// Wait Wait for All Goroutines to Finish (false if Still Running after grace)
+func (s *Supervisor) Wait(grace time.Duration) bool {
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(grace):
+		log.Printf("[%s] Grace Period Expired with Goroutines Running %v", s.name, s.Running())
+		return false
+	}
+}
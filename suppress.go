@@ -0,0 +1,115 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/suppression"
+)
+
+// suppressCommand Manage Suppression List (Returns Exit Code)
+func suppressCommand(args []string) int {
+	flags := flag.NewFlagSet("suppress", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Manage Suppression List
+
+		Usage:
+		  server suppress [-c /path/to/conf] list
+		  server suppress [-c /path/to/conf] add <address> [reason]
+		  server suppress [-c /path/to/conf] remove <address>
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	flags.Parse(args)
+
+	// Do we have an Action?
+	if flags.NArg() == 0 { // NO
+		flags.Usage()
+		return 2
+	}
+
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Is Suppression Configured?
+	if c.Suppression == nil { // NO
+		fmt.Fprintln(os.Stderr, "ERROR: No Suppression List in Configuration File")
+		return 1
+	}
+
+	l, err := suppression.Open(c.Suppression)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	action := flags.Arg(0)
+	switch action {
+	case "list":
+		for _, e := range l.Entries() {
+			fmt.Printf("%s\t%s\t%s\n", e.Address, e.Added, e.Reason)
+		}
+	case "add":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return 2
+		}
+
+		reason := strings.Join(flags.Args()[2:], " ")
+		if reason == "" {
+			reason = "manual"
+		}
+
+		err = l.Add(flags.Arg(1), reason)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Suppressed [%s]\n", flags.Arg(1))
+	case "remove":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return 2
+		}
+
+		removed, err := l.Remove(flags.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		// Was Address Suppressed?
+		if !removed { // NO
+			fmt.Printf("Not Suppressed [%s]\n", flags.Arg(1))
+			return 1
+		}
+		fmt.Printf("Removed [%s]\n", flags.Arg(1))
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,328 @@
+package suppression
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/objectvault/queue-interface/shared"
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Suppressed Address
+type Entry struct {
+	Address string // Email Address (Lower Case)
+	Reason  string // Why Address was Suppressed
+	Added   string // Time Stamp
+}
+
+// Suppression List (File with One Entry per Line: address<TAB>reason<TAB>timestamp)
+type List struct {
+	lock     sync.Mutex
+	path     string            // List File
+	exempt   map[string]bool   // Templates Sent even to Suppressed Addresses
+	entries  map[string]*Entry // Suppressed Addresses
+	modified time.Time         // File Modification Time when Loaded
+	size     int64             // File Size when Loaded
+}
+
+// Open Suppression List (nil List if Suppression is not Configured)
+func Open(c *config.Suppression) (*List, error) {
+	// Is Suppression Enabled?
+	if c == nil { // NO
+		return nil, nil
+	}
+
+	l := &List{
+		path:   c.Path,
+		exempt: map[string]bool{},
+	}
+
+	// NOTE: Template Names are always lower case
+	for _, t := range c.Exempt {
+		l.exempt[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	err := l.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func normalize(address string) string {
+	address = strings.TrimSpace(address)
+
+	// Strip Display Name (i.e. "Name <user@domain>")
+	if i := strings.LastIndex(address, "<"); i >= 0 {
+		address = strings.TrimRight(address[i+1:], "> ")
+	}
+
+	return strings.ToLower(address)
+}
+
+// load (Re)Load List from File if Changed (Lock Held)
+func (l *List) load() error {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) { // Empty List
+		l.entries = map[string]*Entry{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Has the File Changed since Last Load?
+	if (l.entries != nil) && info.ModTime().Equal(l.modified) && (info.Size() == l.size) { // NO
+		return nil
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries := map[string]*Entry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip Empty Lines and Comments
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 3)
+		e := &Entry{Address: normalize(fields[0])}
+		if len(fields) > 1 {
+			e.Reason = fields[1]
+		}
+		if len(fields) > 2 {
+			e.Added = fields[2]
+		}
+		entries[e.Address] = e
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	l.entries = entries
+	l.modified, l.size = info.ModTime(), info.Size()
+	log.Printf("Suppression List [%s] Loaded [%d] Addresses", l.path, len(entries))
+	return nil
+}
+
+// Exempt Is Template Sent even to Suppressed Addresses?
+func (l *List) Exempt(template string) bool {
+	if l == nil {
+		return true
+	}
+
+	return l.exempt[strings.ToLower(template)]
+}
+
+// Lookup Suppression Entry for Address (nil if not Suppressed)
+func (l *List) Lookup(address string) *Entry {
+	// Is Suppression Enabled?
+	if l == nil { // NO
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Pick Up Changes Made by Admin Commands
+	err := l.load()
+	if err != nil {
+		log.Printf("[Lookup] Failed to Reload Suppression List [%s]", err)
+	}
+
+	return l.entries[normalize(address)]
+}
+
+// Add Address to Suppression List
+func (l *List) Add(address string, reason string) error {
+	if l == nil {
+		return nil
+	}
+
+	address = normalize(address)
+	if address == "" {
+		return fmt.Errorf("Invalid Address")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Daemon and Admin Commands Share the File
+	unlock, err := l.flock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Already Suppressed?
+	l.load()
+	if _, ok := l.entries[address]; ok { // YES
+		return nil
+	}
+
+	// Reasons are Single Line and Tab Free
+	reason = strings.Join(strings.Fields(reason), " ")
+	e := &Entry{Address: address, Reason: reason, Added: shared.UTCTimeStamp()}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", e.Address, e.Reason, e.Added)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	l.entries[address] = e
+	l.stat()
+	return nil
+}
+
+// Remove Address from Suppression List (Returns false if not Suppressed)
+func (l *List) Remove(address string) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+
+	address = normalize(address)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Daemon and Admin Commands Share the File
+	unlock, err := l.flock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// Rewrite must Start from Current File
+	err = l.load()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := l.entries[address]; !ok {
+		return false, nil
+	}
+
+	delete(l.entries, address)
+	return true, l.save()
+}
+
+// flock Take Advisory Lock Shared with Other Processes (Lock Held), Returns Release
+// NOTE: Lock is on a Sidecar File, as save() Replaces the List File
+func (l *List) flock() (func(), error) {
+	file, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Closing the File Releases the Lock
+	return func() { file.Close() }, nil
+}
+
+// stat Record File State after Write (Lock Held)
+func (l *List) stat() {
+	if info, err := os.Stat(l.path); err == nil {
+		l.modified, l.size = info.ModTime(), info.Size()
+	}
+}
+
+// save Rewrite List File (Lock Held)
+func (l *List) save() error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".suppressed-*")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range l.sorted() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Address, e.Reason, e.Added)
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	l.stat()
+	return nil
+}
+
+func (l *List) sorted() []*Entry {
+	entries := make([]*Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+	return entries
+}
+
+// Entries All Suppressed Addresses (Sorted)
+func (l *List) Entries() []*Entry {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.load()
+	return l.sorted()
+}
@@ -0,0 +1,73 @@
+package suppression
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+)
+
+// Daemon Appending while Admin Command Rewrites the List
+func TestConcurrentAddRemove(t *testing.T) {
+	c := &config.Suppression{Path: filepath.Join(t.TempDir(), "suppressed.txt")}
+
+	daemon, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 200
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			if err := daemon.Add(fmt.Sprintf("kept%d@example.com", i), "Bounced"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			address := fmt.Sprintf("removed%d@example.com", i)
+			if err := admin.Add(address, "Complaint"); err != nil {
+				t.Error(err)
+			}
+			if _, err := admin.Remove(address); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	l, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(l.Entries()); n != count {
+		t.Errorf("List has [%d] Entries Expected [%d]", n, count)
+	}
+	for i := 0; i < count; i++ {
+		if address := fmt.Sprintf("kept%d@example.com", i); l.Lookup(address) == nil {
+			t.Fatalf("Address [%s] Lost", address)
+		}
+	}
+}
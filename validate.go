@@ -0,0 +1,161 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/mailer"
+)
+
+// validateTemplate Parse Template and its Locale Variants, Check and Render Sample Parameters (Returns Report Note)
+//
+// Locale variants are checked against the base template's sample parameters.
+func validateTemplate(c *config.DaemonConfig, name string) (string, error) {
+	locales, err := mailer.Locales(c, name)
+	if err != nil {
+		return "", err
+	}
+
+	// Base Template First
+	locales = append([]string{""}, locales...)
+	for _, locale := range locales {
+		err = mailer.ParseTemplate(c, name, locale)
+		if err != nil {
+			return "", localeError(locale, err)
+		}
+	}
+
+	// Locale Variants Noted in Report
+	variants := ""
+	if len(locales) > 1 {
+		variants = " [" + strings.Join(locales[1:], ", ") + "]"
+	}
+
+	// Do we have Sample Parameters?
+	params, err := mailer.SampleParameters(c, name)
+	if err != nil {
+		return "", err
+	}
+
+	if params == nil { // NO: Only Syntax Checked
+		return "parsed (no sample parameters)" + variants, nil
+	}
+
+	// Do Sample Parameters Match the Schema?
+	err = mailer.CheckParameters(c, name, params)
+	if err != nil {
+		return "", err
+	}
+
+	for _, locale := range locales {
+		_, err = mailer.Render(c, name, locale, params)
+		if err != nil {
+			return "", localeError(locale, err)
+		}
+	}
+
+	return "rendered with sample parameters" + variants, nil
+}
+
+// localeError Name Locale Variant in Error (Base Template Errors Unchanged)
+func localeError(locale string, err error) error {
+	if locale == "" {
+		return err
+	}
+	return fmt.Errorf("locale [%s]: %w", locale, err)
+}
+
+// validateCommand Validate Configuration and Templates (Returns Exit Code)
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		usage := `
+		Validate Configuration and Templates
+
+		Usage:
+		  server validate [-c /path/to/conf] [-relay]
+
+		  Options:
+		    -c            Path to configuration file [default: ./mailer.json].
+		    -relay        Also connect (and authenticate) to the SMTP relay.
+
+		  Every template in the templates directory (and each of its locale
+		  variants, {name}.{locale}.text|html.template) is parsed and, if a
+		  {name}.sample.json file exists, its parameters are checked against
+		  {name}.schema.json (if any) and rendered for each locale.
+		`
+
+		fmt.Println(usage)
+	}
+	sConfPath := flags.String("c", "./mailer.json", "Path to configuration file")
+	bRelay := flags.Bool("relay", false, "Check SMTP relay")
+	flags.Parse(args)
+
+	failures := 0
+	report := func(ok bool, item string, note string) {
+		status := "OK  "
+		if !ok {
+			status = "FAIL"
+			failures++
+		}
+		fmt.Printf("%s %-30s %s\n", status, item, note)
+	}
+
+	// Configuration
+	c, err := config.Load(*sConfPath)
+	if err != nil {
+		report(false, "config", err.Error())
+		return 1
+	}
+	report(true, "config", *sConfPath)
+
+	// Relay
+	if *bRelay {
+		relay := mailer.Relay(c)
+		err = mailer.CheckRelay(c, 10*time.Second)
+		if err != nil {
+			report(false, "relay", relay+": "+err.Error())
+		} else {
+			report(true, "relay", relay)
+		}
+	}
+
+	// Templates
+	names, err := mailer.Templates(c)
+	if err != nil {
+		report(false, "templates", err.Error())
+	} else if len(names) == 0 {
+		report(false, "templates", "no templates in "+c.Paths.Templates)
+	}
+
+	for _, name := range names {
+		note, err := validateTemplate(c, name)
+		if err != nil {
+			report(false, "template "+name, err.Error())
+		} else {
+			report(true, "template "+name, note)
+		}
+	}
+
+	// Summary
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "%d Check(s) Failed\n", failures)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/objectvault/queue-smtp-mailer/config"
+	"github.com/objectvault/queue-smtp-mailer/mailer"
+)
+
+func TestValidateLocales(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"welcome.text.template":    "Hello {{.name}}",
+		"welcome.pt.text.template": "Olá {{.name}}",
+		"welcome.sample.json":      `{"Name": "Ana"}`,
+		"welcome.schema.json":      `{"type": "object", "required": ["name"]}`,
+		"invoice.text.template":    "Total {{.total}}",
+		"invoice.de.text.template": `Summe {{index .total 0}}`,
+		"invoice.sample.json":      `{"total": 10}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &config.DaemonConfig{Paths: &config.Paths{Templates: dir}}
+
+	// Locale Variants are not Templates of their Own
+	names, err := mailer.Templates(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"invoice", "welcome"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Templates %v Expected %v", names, want)
+	}
+
+	note, err := validateTemplate(c, "welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note != "rendered with sample parameters [pt]" {
+		t.Errorf("Unexpected Note [%s]", note)
+	}
+
+	// Locale Variants Checked with the Base Template's Samples
+	_, err = validateTemplate(c, "invoice")
+	if (err == nil) || !strings.Contains(err.Error(), "locale [de]") {
+		t.Errorf("Error [%v] Expected Locale [de] Failure", err)
+	}
+}
@@ -0,0 +1,81 @@
+package window
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"time"
+)
+
+// Daily Delivery Window in Local Time
+type Window struct {
+	start int // Opens (Minutes after Midnight)
+	end   int // Closes (Minutes after Midnight, Before start Wraps Midnight)
+}
+
+// clock Minutes after Midnight for HH:MM
+func clock(s string) (int, error) {
+	var h, m int
+	n, err := fmt.Sscanf(s, "%d:%d", &h, &m)
+	if (err != nil) || (n != 2) || (len(s) != 5) || (h < 0) || (h > 23) || (m < 0) || (m > 59) {
+		return 0, fmt.Errorf("Invalid Time [%s] (expected HH:MM)", s)
+	}
+
+	return h*60 + m, nil
+}
+
+// Parse Window Opening at start and Closing at end (HH:MM, i.e. 08:00 to 20:00)
+//
+// A window ending before it starts wraps midnight (i.e. 22:00 to 06:00).
+func Parse(start string, end string) (*Window, error) {
+	s, err := clock(start)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := clock(end)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == e {
+		return nil, fmt.Errorf("Empty Window [%s-%s]", start, end)
+	}
+
+	return &Window{start: s, end: e}, nil
+}
+
+// Contains Is Time (in its Location) within Window?
+func (w *Window) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+
+	// Does the Window Wrap Midnight?
+	if w.start < w.end { // NO
+		return (m >= w.start) && (m < w.end)
+	}
+
+	return (m >= w.start) || (m < w.end)
+}
+
+// Next Earliest Time, at or after t, within Window (Evaluated in t's Location)
+func (w *Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	// Today's Opening, if Still Ahead, else Tomorrow's
+	y, m, d := t.Date()
+	open := time.Date(y, m, d, w.start/60, w.start%60, 0, 0, t.Location())
+	if !open.After(t) {
+		open = time.Date(y, m, d+1, w.start/60, w.start%60, 0, 0, t.Location())
+	}
+	return open
+}
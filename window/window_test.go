@@ -0,0 +1,65 @@
+package window
+
+/*
+ * This file is part of the ObjectVault Project.
+ * Copyright (C) 2020-2022 Paulo Ferreira <vault at sourcenotes.org>
+ *
+ * This work is published under the GNU AGPLv3.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, bad := range [][2]string{{"8:00", "20:00"}, {"08:00", "24:00"}, {"08:60", "20:00"}, {"08:00", "08:00"}, {"", "20:00"}} {
+		if _, err := Parse(bad[0], bad[1]); err == nil {
+			t.Errorf("Invalid Window Accepted %v", bad)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("No Timezone Database")
+	}
+
+	day, _ := Parse("08:00", "20:00")
+	night, _ := Parse("22:00", "06:00")
+
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		w    *Window
+		t    time.Time
+		want time.Time
+	}{
+		{"inside", day, at("2024-03-05 12:30"), at("2024-03-05 12:30")},
+		{"before opening", day, at("2024-03-05 03:00"), at("2024-03-05 08:00")},
+		{"after closing", day, at("2024-03-05 20:00"), at("2024-03-06 08:00")},
+		{"wrapping inside", night, at("2024-03-05 23:00"), at("2024-03-05 23:00")},
+		{"wrapping early", night, at("2024-03-05 05:59"), at("2024-03-05 05:59")},
+		{"wrapping outside", night, at("2024-03-05 12:00"), at("2024-03-05 22:00")},
+		{"across dst change", day, at("2024-03-09 21:00"), at("2024-03-10 08:00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.Next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("Next [%s] Expected [%s]", got, tt.want)
+			}
+		})
+	}
+}